@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// users holds a User for each lower cased name.
+	users = []byte("users")
+	// sessions holds a Session for each session token.
+	sessions = []byte("sessions")
+)
+
+// sessionCookie names the cookie a login is kept in.
+const sessionCookie = "growser_session"
+
+// sessionAge is how long a login lasts.
+const sessionAge = 30 * 24 * time.Hour
+
+// aiSeat is the seat of the AI in a Record.
+const aiSeat = "ai"
+
+// minPassword is the shortest password accepted.
+const minPassword = 8
+
+// User is an account, stored as json in the users
+// bucket. The hash is bcrypt's, which salts it.
+type User struct {
+	Name    string    `json:"name"`
+	Hash    []byte    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// Session is a login, stored as json by its token.
+type Session struct {
+	User    string    `json:"user"` // the lower cased name
+	Expires time.Time `json:"expires"`
+}
+
+// userKey is the context key of the logged in User.
+type userKey struct{}
+
+// guestKey is the context key of the guest id.
+type guestKey struct{}
+
+// dummyHash is compared against for unknown names, so
+// logging in takes as long whether the name exists.
+var dummyHash = []byte("$2a$10$lC0/1hJnUQxuoR524I6hGOsuo3Qkq4RZUUjX5d76qOtfL41WYOQTm")
+
+var (
+	errUserName  = errors.New("Names are 3 to 20 letters, digits, - or _")
+	errUserTaken = errors.New("That name is taken")
+	errPassword  = fmt.Errorf("Passwords are at least %d characters", minPassword)
+	errPasswords = errors.New("The passwords differ")
+	errLogin     = errors.New("Wrong name or password")
+)
+
+// validName returns true if name may be registered.
+func validName(name string) bool {
+	if len(name) < 3 || len(name) > 20 || strings.EqualFold(name, aiSeat) {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// getUser reads the User of a name, nil if unknown.
+func getUser(tx *bolt.Tx, name string) *User {
+	bucket := tx.Bucket(users)
+	if bucket == nil {
+		return nil
+	}
+	val := bucket.Get([]byte(strings.ToLower(name)))
+	if val == nil {
+		return nil
+	}
+	var u User
+	if json.Unmarshal(val, &u) != nil {
+		return nil
+	}
+	return &u
+}
+
+// createUser registers name with password.
+func createUser(name, password string) (*User, error) {
+	if !validName(name) {
+		return nil, errUserName
+	}
+	if len(password) < minPassword {
+		return nil, errPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{Name: name, Hash: hash, Created: time.Now()}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(users)
+		if err != nil {
+			return err
+		}
+		key := []byte(strings.ToLower(name))
+		if bucket.Get(key) != nil {
+			return errUserTaken
+		}
+		val, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, val)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// checkLogin returns the User of name if password is theirs.
+func checkLogin(name, password string) (*User, error) {
+	var u *User
+	err := db.View(func(tx *bolt.Tx) error {
+		u = getUser(tx, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errLogin
+	}
+	if bcrypt.CompareHashAndPassword(u.Hash, []byte(password)) != nil {
+		return nil, errLogin
+	}
+	return u, nil
+}
+
+// startSession logs u in, setting the session cookie.
+func startSession(w http.ResponseWriter, r *http.Request, u *User) error {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	s := Session{User: strings.ToLower(u.Name), Expires: time.Now().Add(sessionAge)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(sessions)
+		if err != nil {
+			return err
+		}
+		val, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(token), val)
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionUser returns the User logged in by r's
+// session cookie, nil if none.
+func sessionUser(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	var u *User
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessions)
+		if bucket == nil {
+			return nil
+		}
+		val := bucket.Get([]byte(c.Value))
+		if val == nil {
+			return nil
+		}
+		var s Session
+		if json.Unmarshal(val, &s) != nil || time.Now().After(s.Expires) {
+			return nil
+		}
+		u = getUser(tx, s.User)
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return u
+}
+
+// endSession logs r out, forgetting its session.
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(sessions)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.Delete([]byte(c.Value))
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// expireSessions deletes the sessions which ran out.
+func expireSessions() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(sessions)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		var expired [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var s Session
+			if json.Unmarshal(v, &s) != nil || now.After(s.Expires) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Authenticate puts the logged in User, if any, and
+// the guest id on the context of the request, see
+// userFrom and seatOf.
+func Authenticate(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), guestKey{}, guestId(w, r))
+		if u := sessionUser(r); u != nil {
+			ctx = context.WithValue(ctx, userKey{}, u)
+		}
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// userFrom returns the logged in User of r, nil if
+// nobody is logged in.
+func userFrom(r *http.Request) *User {
+	u, _ := r.Context().Value(userKey{}).(*User)
+	return u
+}
+
+// guestFrom returns the guest id of r.
+func guestFrom(r *http.Request) string {
+	id, _ := r.Context().Value(guestKey{}).(string)
+	return id
+}
+
+// seatOf returns the name r's player sits in a Record
+// under, the user's or else the guest's.
+func seatOf(r *http.Request) string {
+	if u := userFrom(r); u != nil {
+		return u.Name
+	}
+	if id := guestFrom(r); id != "" {
+		return guestPrefix + id
+	}
+	return ""
+}
+
+// AccountPage is what the login and register forms show.
+type AccountPage struct {
+	Title  string
+	Action string
+	Name   string
+	Error  string
+}
+
+// accountForm shows the login or register form.
+func accountForm(w http.ResponseWriter, page AccountPage) {
+	t, err := template.ParseFiles("templates/account.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	t.Execute(w, page)
+}
+
+// Register shows the form for a new account, and
+// on POST creates it and logs in.
+func Register(w http.ResponseWriter,
+	r *http.Request) {
+	page := AccountPage{Title: "Register", Action: "/register"}
+	if r.Method != "POST" {
+		accountForm(w, page)
+		return
+	}
+	page.Name = r.PostFormValue("name")
+	password := r.PostFormValue("password")
+	var u *User
+	err := errPasswords
+	if password == r.PostFormValue("confirm") {
+		u, err = createUser(page.Name, password)
+	}
+	if err == nil {
+		err = startSession(w, r, u)
+	}
+	// What they played as a guest is theirs now
+	if guest := guestFrom(r); err == nil && guest != "" {
+		err = claimGuest(guest, u.Name)
+	}
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		accountForm(w, page)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Login shows the login form, and on POST logs in.
+func Login(w http.ResponseWriter,
+	r *http.Request) {
+	page := AccountPage{Title: "Login", Action: "/login"}
+	if r.Method != "POST" {
+		accountForm(w, page)
+		return
+	}
+	page.Name = r.PostFormValue("name")
+	u, err := checkLogin(page.Name, r.PostFormValue("password"))
+	if err == nil {
+		err = startSession(w, r, u)
+	}
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+		accountForm(w, page)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout ends the session and returns to the index.
+func Logout(w http.ResponseWriter,
+	r *http.Request) {
+	endSession(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
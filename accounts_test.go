@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidName(t *testing.T) {
+	cases := map[string]bool{
+		"alice":                 true,
+		"Bob_2-x":               true,
+		"al":                    false,
+		"AI":                    false,
+		"ai":                    false,
+		"bad name":              false,
+		"élodie":                false,
+		"abcdefghijklmnopqrst":  true,
+		"abcdefghijklmnopqrstu": false,
+	}
+	for name, want := range cases {
+		if got := validName(name); got != want {
+			t.Errorf("%q: %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	openTestDb(t)
+	if _, err := createUser("alice", "short"); err != errPassword {
+		t.Errorf("short password: %v", err)
+	}
+	if _, err := createUser("a!", "long enough"); err != errUserName {
+		t.Errorf("bad name: %v", err)
+	}
+	if _, err := createUser("Alice", "long enough"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createUser("alice", "other password"); err != errUserTaken {
+		t.Errorf("taken name: %v", err)
+	}
+	u, err := checkLogin("ALICE", "long enough")
+	if err != nil || u.Name != "Alice" {
+		t.Errorf("login %v %v", u, err)
+	}
+	if _, err := checkLogin("alice", "long enougH"); err != errLogin {
+		t.Errorf("wrong password: %v", err)
+	}
+	if _, err := checkLogin("nobody", "long enough"); err != errLogin {
+		t.Errorf("unknown name: %v", err)
+	}
+}
+
+// A session cookie logs its user in until the session
+// ends.
+func TestSession(t *testing.T) {
+	openTestDb(t)
+	u, err := createUser("alice", "long enough")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := startSession(w, httptest.NewRequest("POST", "/login", nil), u); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	seated := func() string {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		var seat string
+		Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u := userFrom(r); u != nil {
+				seat = u.Name
+			}
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return seat
+	}
+	if seat := seated(); seat != "alice" {
+		t.Errorf("logged in as %q", seat)
+	}
+	r := httptest.NewRequest("POST", "/logout", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	endSession(httptest.NewRecorder(), r)
+	if seat := seated(); seat != "" {
+		t.Errorf("still logged in as %q", seat)
+	}
+}
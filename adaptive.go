@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// players holds a Player for each seat, see seatOf.
+var players = []byte("players")
+
+// defaultLevel is the level a new player starts at,
+// Medium like computer.html always was.
+const defaultLevel = 4
+
+// Player is what we keep about someone playing the AI,
+// stored as json in the players bucket by their seat.
+type Player struct {
+	Id      string                `json:"id"`
+	Level   int                   `json:"level"`   // the level Auto plays
+	Results map[int]*LevelResults `json:"results"` // by level
+}
+
+// LevelResults are a player's results against a level.
+type LevelResults struct {
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+}
+
+// getPlayer reads a Player, an unknown id gets a new
+// Player at the default level.
+func getPlayer(tx *bolt.Tx, id string) Player {
+	p := Player{Id: id, Level: defaultLevel}
+	bucket := tx.Bucket(players)
+	if bucket != nil {
+		if val := bucket.Get([]byte(id)); val != nil {
+			json.Unmarshal(val, &p)
+		}
+	}
+	if p.Results == nil {
+		p.Results = make(map[int]*LevelResults)
+	}
+	return p
+}
+
+// putPlayer writes a Player.
+func putPlayer(tx *bolt.Tx, p Player) error {
+	bucket, err := tx.CreateBucketIfNotExists(players)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(p.Id), val)
+}
+
+// movePlayer moves the Player of from to to, unless
+// to has played already.
+func movePlayer(tx *bolt.Tx, from, to string) error {
+	bucket, err := tx.CreateBucketIfNotExists(players)
+	if err != nil {
+		return err
+	}
+	val := bucket.Get([]byte(from))
+	if val == nil || bucket.Get([]byte(to)) != nil {
+		return nil
+	}
+	p := getPlayer(tx, from)
+	p.Id = to
+	err = putPlayer(tx, p)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete([]byte(from))
+}
+
+// finish counts a game against level, score being 1, 0.5
+// or 0 for the player. The level Auto plays goes up after
+// a win and down after a loss, a staircase which settles
+// where the player scores about half.
+func (p *Player) finish(level int, score float64) {
+	res, ok := p.Results[level]
+	if !ok {
+		res = &LevelResults{}
+		p.Results[level] = res
+	}
+	switch score {
+	case 1:
+		res.Wins++
+		if _, ok := levels[level+1]; ok {
+			p.Level = level + 1
+		} else {
+			p.Level = level
+		}
+	case 0:
+		res.Losses++
+		if _, ok := levels[level-1]; ok {
+			p.Level = level - 1
+		} else {
+			p.Level = level
+		}
+	default:
+		res.Draws++
+		p.Level = level
+	}
+}
+
+// playerLevel returns the level Auto plays for id.
+func playerLevel(id string) int {
+	level := defaultLevel
+	err := db.View(func(tx *bolt.Tx) error {
+		level = getPlayer(tx, id).Level
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return level
+}
+
+// finishGame counts a finished game of id at level, in
+// which the player had colour, and returns the level
+// Auto plays next.
+func finishGame(id string, level int, colour, result string) int {
+	score, ok := resultScore(result)
+	if !ok {
+		return level
+	}
+	if colour == "b" {
+		score = 1 - score
+	}
+	var next int
+	err := db.Update(func(tx *bolt.Tx) error {
+		p := getPlayer(tx, id)
+		p.finish(level, score)
+		next = p.Level
+		return putPlayer(tx, p)
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return next
+}
+
+// levelName returns the name computer.html shows for
+// a level.
+func levelName(level int) string {
+	if h, ok := levels[level]; ok {
+		return h.Name
+	}
+	return fmt.Sprintf("level %d", level)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The level goes up after a win and down after a
+// loss, within Beginner and Hard.
+func TestPlayerFinish(t *testing.T) {
+	p := Player{Id: "p", Level: defaultLevel, Results: make(map[int]*LevelResults)}
+	cases := []struct {
+		level int
+		score float64
+		next  int
+	}{
+		{4, 1, 5},
+		{5, 1, 5},
+		{5, 0.5, 5},
+		{5, 0, 4},
+		{2, 0, 1},
+		{1, 0, 1},
+		{3, 0.5, 3},
+	}
+	for _, c := range cases {
+		p.finish(c.level, c.score)
+		if p.Level != c.next {
+			t.Errorf("%v at %d: level %d, want %d", c.score, c.level, p.Level, c.next)
+		}
+	}
+	want := map[int]*LevelResults{
+		1: {Losses: 1},
+		2: {Losses: 1},
+		3: {Draws: 1},
+		4: {Wins: 1},
+		5: {Wins: 1, Draws: 1, Losses: 1},
+	}
+	if !reflect.DeepEqual(p.Results, want) {
+		t.Errorf("results %v", p.Results)
+	}
+}
+
+func TestFinishGame(t *testing.T) {
+	openTestDb(t)
+	if level := playerLevel("new"); level != defaultLevel {
+		t.Errorf("new player at %d", level)
+	}
+	// Black wins, the player had black
+	if next := finishGame("p", 4, "b", "0-1"); next != 5 {
+		t.Errorf("after a win %d, want 5", next)
+	}
+	if level := playerLevel("p"); level != 5 {
+		t.Errorf("stored level %d, want 5", level)
+	}
+	if next := finishGame("p", 5, "w", "0-1"); next != 4 {
+		t.Errorf("after a loss %d, want 4", next)
+	}
+	if next := finishGame("p", 4, "w", ""); next != 4 {
+		t.Errorf("unfinished game moved to %d", next)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/polypmer/ghess"
+)
+
+var analyses = []byte("analysis")
+
+// analysisDepth is how many plies the analysis
+// searches for the best move of every position.
+const analysisDepth = 3
+
+// Centipawns lost against the engine's best move
+// for a move to be called a blunder, mistake or inaccuracy.
+const (
+	blunderSwing    = 300
+	mistakeSwing    = 100
+	inaccuracySwing = 50
+)
+
+// startFen is the starting position as ghess writes it.
+const startFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// Ply is the analysis of a single move.
+type Ply struct {
+	Ply   int    `json:"ply"`
+	Move  string `json:"move"`  // as played, in SAN
+	Uci   string `json:"uci"`   // as played, eg "e2e4"
+	Best  string `json:"best"`  // the engine's choice, in SAN
+	Eval  int    `json:"eval"`  // after the move, positive for white
+	Swing int    `json:"swing"` // centipawns lost against Best
+	Class string `json:"class"` // blunder, mistake, inaccuracy or good
+	Nag   string `json:"nag"`
+}
+
+// Analysis is the report of a finished game.
+// It is stored as json in the analysis bucket.
+type Analysis struct {
+	Id     string `json:"id"`
+	Result string `json:"result"`
+	Depth  int    `json:"depth"`
+	Plies  []Ply  `json:"plies"`
+	Graph  []int  `json:"graph"` // Eval of every ply, clamped for plotting
+	Pgn    string `json:"pgn"`   // annotated
+}
+
+// classify names a move from the centipawns it lost,
+// and returns its PGN NAG.
+func classify(swing int) (string, string) {
+	switch {
+	case swing >= blunderSwing:
+		return "blunder", "$4"
+	case swing >= mistakeSwing:
+		return "mistake", "$2"
+	case swing >= inaccuracySwing:
+		return "inaccuracy", "$6"
+	}
+	return "good", ""
+}
+
+// analyseGame walks every ply of the game's Record
+// with the engine and writes the annotated report.
+func analyseGame(rec Record) (Analysis, error) {
+	a := Analysis{Id: rec.Id, Result: rec.Result, Depth: analysisDepth}
+	a.Plies = make([]Ply, 0, len(rec.Moves))
+	a.Graph = make([]int, 0, len(rec.Moves))
+	if len(rec.Moves) < 1 {
+		return a, errors.New("No moves recorded")
+	}
+	game := ghess.NewBoard()
+	start := startFen
+	if rec.Start != "" {
+		start = rec.Start
+		err := game.LoadFen(start)
+		if err != nil {
+			return a, err
+		}
+	}
+	var movetext []string
+	needNumber := true // after a variation black needs "n..."
+	for i, mv := range rec.Moves {
+		orig, dest, err := parseMoveString(mv)
+		if err != nil {
+			return a, err
+		}
+		sign := 1
+		if turnOf(&game) == "b" {
+			sign = -1
+		}
+		number, _ := strconv.Atoi(game.Stats()["move"])
+		res, err := analysisEngine.BestMove(&game, analysisLimits(analysisDepth))
+		if err != nil {
+			return a, err
+		}
+		bestScore, best := res.Score, [2]int{res.Orig, res.Dest}
+		played := san(&game, orig, dest)
+		bestSan := ""
+		if best[0] != 0 {
+			bestSan = san(&game, best[0], best[1])
+		}
+		next := ghess.CopyBoard(&game)
+		err = next.Move(orig, dest)
+		if err != nil {
+			return a, fmt.Errorf("Ply %d %s: %s", i+1, mv, err)
+		}
+		playedScore := bestScore
+		if best != [2]int{orig, dest} {
+			res, err = analysisEngine.BestMove(next, analysisLimits(analysisDepth-1))
+			if err != nil {
+				return a, err
+			}
+			playedScore = -res.Score
+		}
+		swing := bestScore - playedScore
+		if swing < 0 {
+			swing = 0
+		}
+		class, nag := classify(swing)
+		p := Ply{
+			Ply:   i + 1,
+			Move:  played,
+			Uci:   mv,
+			Best:  bestSan,
+			Eval:  sign * playedScore,
+			Swing: swing,
+			Class: class,
+			Nag:   nag,
+		}
+		a.Plies = append(a.Plies, p)
+		a.Graph = append(a.Graph, clamp(p.Eval, 2000))
+
+		// Movetext
+		if sign == 1 {
+			movetext = append(movetext, strconv.Itoa(number)+".")
+		} else if needNumber {
+			movetext = append(movetext, strconv.Itoa(number)+"...")
+		}
+		needNumber = false
+		movetext = append(movetext, played)
+		if nag != "" {
+			movetext = append(movetext, nag)
+			variation := "(" + strconv.Itoa(number) + "."
+			if sign == -1 {
+				variation += ".."
+			}
+			movetext = append(movetext, variation, bestSan+")",
+				"{"+class+"}")
+			needNumber = true
+		}
+		game = *next
+	}
+	result := rec.Result
+	if result == "" {
+		result = "*"
+	}
+	movetext = append(movetext, result)
+
+	headers := fmt.Sprintf("[Event \"growser game %s\"]\n", rec.Id)
+	headers += "[Site \"growser\"]\n"
+	headers += "[Date \"" + pgnDate(rec) + "\"]\n"
+	headers += "[Round \"-\"]\n"
+	headers += "[White \"" + pgnPlayer(rec.White, rec.Level) + "\"]\n"
+	headers += "[Black \"" + pgnPlayer(rec.Black, rec.Level) + "\"]\n"
+	headers += "[Result \"" + result + "\"]\n"
+	headers += "[Annotator \"growser\"]\n"
+	if start != startFen {
+		headers += "[SetUp \"1\"]\n[FEN \"" + start + "\"]\n"
+	}
+	a.Pgn = headers + "\n" + wrap(movetext, 79) + "\n"
+	return a, nil
+}
+
+// pgnDate is when the game was played, as YYYY.MM.DD,
+// the day it ended or else the day it was made.
+func pgnDate(rec Record) string {
+	when := rec.Ended
+	if when.IsZero() {
+		when = rec.Created
+	}
+	if when.IsZero() {
+		return "????.??.??"
+	}
+	return when.Format("2006.01.02")
+}
+
+// pgnPlayer names a seat for the PGN headers, guests
+// without their ids.
+func pgnPlayer(seat string, level int) string {
+	switch {
+	case seat == aiSeat && level > 0:
+		return "growser, " + levelName(level)
+	case seat == aiSeat:
+		return "growser"
+	case seat == "":
+		return "?"
+	case strings.HasPrefix(seat, guestPrefix):
+		return "Guest"
+	}
+	return seat
+}
+
+// analysisLimits bounds the analysis searches, by depth
+// for ghess and by time for other engines.
+func analysisLimits(depth int) Limits {
+	return Limits{Depth: depth, MoveTime: engineMoveTime}
+}
+
+// clamp keeps n between -limit and limit.
+func clamp(n, limit int) int {
+	switch {
+	case n > limit:
+		return limit
+	case n < -limit:
+		return -limit
+	}
+	return n
+}
+
+// wrap joins tokens by spaces into lines of at most width.
+func wrap(tokens []string, width int) string {
+	var lines []string
+	var line string
+	for _, t := range tokens {
+		if line != "" && len(line)+1+len(t) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += t
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+// analysing holds the ids of running analysis jobs,
+// so a game is only analysed once.
+var analysing = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+// startAnalysis launches the analysis job of a
+// finished game, unless it's running or done.
+func startAnalysis(id string) {
+	analysing.Lock()
+	defer analysing.Unlock()
+	if analysing.ids[id] {
+		return
+	}
+	analysing.ids[id] = true
+	go func() {
+		defer func() {
+			analysing.Lock()
+			delete(analysing.ids, id)
+			analysing.Unlock()
+		}()
+		var rec Record
+		var done bool
+		db.View(func(tx *bolt.Tx) error {
+			rec = getRecord(tx, id)
+			bucket := tx.Bucket(analyses)
+			done = bucket != nil && bucket.Get([]byte(id)) != nil
+			return nil
+		})
+		if done {
+			return
+		}
+		a, err := analyseGame(rec)
+		if err != nil {
+			fmt.Println("Analysis", id, err)
+			return
+		}
+		val, err := json.Marshal(a)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(analyses)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(id), val)
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}()
+}
+
+// getAnalysis returns the stored analysis of a game as
+// json, or starts the job if the game is over. The
+// returned status is for the http response.
+func getAnalysis(id string) ([]byte, int) {
+	var val []byte
+	var rec Record
+	db.View(func(tx *bolt.Tx) error {
+		rec = getRecord(tx, id)
+		bucket := tx.Bucket(analyses)
+		if bucket != nil {
+			// Copy, the value is only valid in the transaction
+			val = append(val, bucket.Get([]byte(id))...)
+		}
+		return nil
+	})
+	if len(val) > 0 {
+		return val, http.StatusOK
+	}
+	if rec.Result == "" {
+		return nil, http.StatusConflict
+	}
+	startAnalysis(id)
+	return nil, http.StatusAccepted
+}
+
+// JSON report of a finished game
+func AnalysisGame(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	val, status := getAnalysis(id)
+	w.Header().Set("Content-Type", "application/json")
+	switch status {
+	case http.StatusConflict:
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error": "The game isn't over"}`))
+	case http.StatusAccepted:
+		w.WriteHeader(status)
+		w.Write([]byte(`{"pending": true}`))
+	default:
+		w.Write(val)
+	}
+}
+
+// Annotated PGN of a finished game
+func AnalysisPgn(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	val, status := getAnalysis(id)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch status {
+	case http.StatusConflict:
+		w.WriteHeader(status)
+		w.Write([]byte("The game isn't over\n"))
+	case http.StatusAccepted:
+		w.WriteHeader(status)
+		w.Write([]byte("The analysis is running, try again soon\n"))
+	default:
+		a := Analysis{}
+		json.Unmarshal(val, &a)
+		w.Write([]byte(a.Pgn))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		swing      int
+		class, nag string
+	}{
+		{0, "good", ""},
+		{49, "good", ""},
+		{50, "inaccuracy", "$6"},
+		{100, "mistake", "$2"},
+		{299, "mistake", "$2"},
+		{300, "blunder", "$4"},
+	}
+	for _, c := range cases {
+		class, nag := classify(c.swing)
+		if class != c.class || nag != c.nag {
+			t.Errorf("%d: %s %q, want %s %q", c.swing, class, nag, c.class, c.nag)
+		}
+	}
+}
+
+// Fool's mate, where g4 lets black mate at once.
+func TestAnalyseGame(t *testing.T) {
+	rec := Record{
+		Id:     "1",
+		Moves:  []string{"f2f3", "e7e5", "g2g4", "d8h4"},
+		Result: "0-1",
+		White:  guestPrefix + "me",
+		Black:  "alice",
+		Ended:  time.Date(2016, 3, 7, 12, 0, 0, 0, time.UTC),
+	}
+	a, err := analyseGame(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Plies) != 4 || len(a.Graph) != 4 {
+		t.Fatalf("%d plies, %d graph points", len(a.Plies), len(a.Graph))
+	}
+	if p := a.Plies[2]; p.Class != "blunder" || p.Nag != "$4" {
+		t.Errorf("g4: %s %s, want blunder", p.Class, p.Nag)
+	}
+	if p := a.Plies[3]; p.Move != "Qh4#" || p.Class != "good" {
+		t.Errorf("Qh4#: %s %s", p.Move, p.Class)
+	}
+	for _, want := range []string{"[Date \"2016.03.07\"]", "[White \"Guest\"]",
+		"[Black \"alice\"]", "[Result \"0-1\"]", "2. g4 $4", "Qh4# 0-1"} {
+		if !strings.Contains(a.Pgn, want) {
+			t.Errorf("no %q in\n%s", want, a.Pgn)
+		}
+	}
+	if _, err := analyseGame(Record{Id: "2"}); err == nil {
+		t.Error("analysed a game without moves")
+	}
+}
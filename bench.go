@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// benchPositions are what bench measures, with the
+// perft depth of each.
+var benchPositions = []struct {
+	Name  string
+	Fen   string
+	Depth int
+}{
+	{"Start", startFen, 4},
+	{"Kiwipete", perftSuite[1].Fen, 3},
+	{"Position 3", perftSuite[2].Fen, 4},
+	{"Position 6", perftSuite[6].Fen, 3},
+}
+
+// BenchCommand runs "growser bench", timing the
+// bitboard generator and search against ghess's.
+func BenchCommand(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	depth := flags.Int("depth", 4, "search depth")
+	flags.Parse(args)
+
+	fmt.Println("Perft")
+	for _, pos := range benchPositions {
+		var took [2]time.Duration
+		var nodes [2]int
+		for i, gen := range []string{"bitboard", "ghess"} {
+			start := time.Now()
+			n, _, err := perftOf(pos.Fen, pos.Depth, gen, false)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			took[i], nodes[i] = time.Since(start), n
+		}
+		fmt.Printf("%-12s depth %d  bitboard %9d nps  ghess %9d nps  %5.1fx\n",
+			pos.Name, pos.Depth, nps(nodes[0], took[0]), nps(nodes[1], took[1]),
+			float64(took[1])/float64(took[0]))
+	}
+
+	fmt.Println("Search")
+	for _, pos := range benchPositions {
+		game, err := loadFen(pos.Fen)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var last Info
+		start := time.Now()
+		_, err = NegamaxEngine{}.BestMove(&game, Limits{Depth: *depth,
+			Info: func(i Info) { last = i }})
+		negamaxTook := time.Since(start)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		start = time.Now()
+		_, err = MiniMaxEngine{}.BestMove(&game, Limits{Depth: *depth})
+		minimaxTook := time.Since(start)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%-12s depth %d  negamax %s (%d nodes, %d nps)  minimax %s  %5.1fx\n",
+			pos.Name, *depth, negamaxTook.Round(time.Millisecond), last.Nodes,
+			nps(last.Nodes, negamaxTook), minimaxTook.Round(time.Millisecond),
+			float64(minimaxTook)/float64(negamaxTook))
+	}
+}
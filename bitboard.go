@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/polypmer/ghess"
+)
+
+// Squares are numbered from a1 = 0, b1 = 1 up to
+// h8 = 63, a bitboard has the bit of every square set.
+
+// Colours
+const (
+	White = iota
+	Black
+)
+
+// Kinds of piece, a piece is colour*6 + kind.
+const (
+	Pawn = iota
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+// Empty is the piece on an empty square.
+const Empty = -1
+
+// NoSquare is the en passant square when there's none.
+const NoSquare = -1
+
+// Castling rights
+const (
+	castleWK = 1 << iota
+	castleWQ
+	castleBK
+	castleBQ
+)
+
+// pieceLetters are the FEN letters of the pieces.
+const pieceLetters = "PNBRQKpnbrqk"
+
+// Directions of the rays, the first four run to higher squares.
+var rayDirs = [8][2]int{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, // N, E, NE, NW
+	{0, -1}, {-1, 0}, {-1, -1}, {1, -1}, // S, W, SW, SE
+}
+
+var (
+	rays           [8][64]uint64
+	knightAttacks  [64]uint64
+	kingAttacks    [64]uint64
+	pawnAttacks    [2][64]uint64
+	castleKeep     [64]int // rights kept when a square is touched
+	rookDirs       = []int{0, 1, 4, 5}
+	bishopDirs     = []int{2, 3, 6, 7}
+	knightJumps    = [8][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingSteps      = [8][2]int{{0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}, {-1, -1}, {-1, 0}, {-1, 1}}
+	errInvalidFen  = errors.New("Invalid FEN")
+	errIllegalMove = errors.New("Illegal move")
+)
+
+func init() {
+	onBoard := func(f, r int) bool { return f >= 0 && f < 8 && r >= 0 && r < 8 }
+	for sq := 0; sq < 64; sq++ {
+		f, r := sq%8, sq/8
+		for d, dir := range rayDirs {
+			for nf, nr := f+dir[0], r+dir[1]; onBoard(nf, nr); nf, nr = nf+dir[0], nr+dir[1] {
+				rays[d][sq] |= 1 << uint(nr*8+nf)
+			}
+		}
+		for _, j := range knightJumps {
+			if onBoard(f+j[0], r+j[1]) {
+				knightAttacks[sq] |= 1 << uint((r+j[1])*8+f+j[0])
+			}
+		}
+		for _, s := range kingSteps {
+			if onBoard(f+s[0], r+s[1]) {
+				kingAttacks[sq] |= 1 << uint((r+s[1])*8+f+s[0])
+			}
+		}
+		for _, df := range []int{-1, 1} {
+			if onBoard(f+df, r+1) {
+				pawnAttacks[White][sq] |= 1 << uint((r+1)*8+f+df)
+			}
+			if onBoard(f+df, r-1) {
+				pawnAttacks[Black][sq] |= 1 << uint((r-1)*8+f+df)
+			}
+		}
+		castleKeep[sq] = castleWK | castleWQ | castleBK | castleBQ
+	}
+	castleKeep[0] &^= castleWQ
+	castleKeep[7] &^= castleWK
+	castleKeep[4] &^= castleWK | castleWQ
+	castleKeep[56] &^= castleBQ
+	castleKeep[63] &^= castleBK
+	castleKeep[60] &^= castleBK | castleBQ
+}
+
+// slide returns the squares a slider on sq attacks
+// along dirs, stopping at the first piece of occ.
+func slide(sq int, occ uint64, dirs []int) uint64 {
+	var att uint64
+	for _, d := range dirs {
+		ray := rays[d][sq]
+		if blockers := ray & occ; blockers != 0 {
+			var b int
+			if d < 4 {
+				b = bits.TrailingZeros64(blockers)
+			} else {
+				b = 63 - bits.LeadingZeros64(blockers)
+			}
+			ray ^= rays[d][b]
+		}
+		att |= ray
+	}
+	return att
+}
+
+// Position is a chess position in bitboards, which
+// the engine searches with makeMove and unmakeMove
+// rather than copying ghess Boards.
+type Position struct {
+	pieces   [2][6]uint64 // by colour and kind
+	occupied [2]uint64
+	board    [64]int // piece by square, or Empty
+	side     int
+	castle   int
+	ep       int // en passant target square
+	halfmove int
+	fullmove int
+	hash     uint64 // Zobrist key, the same as Polyglot's
+	undos    []undo
+}
+
+// undo is what unmakeMove needs to take a move back.
+type undo struct {
+	m        move
+	captured int
+	castle   int
+	ep       int
+	halfmove int
+	hash     uint64
+}
+
+// pieceKey is the Zobrist key of piece pc on sq, laid
+// out as in Polyglot's table.
+func pieceKey(pc, sq int) uint64 {
+	return polyglotRandom[64*(2*(pc%6)+1-pc/6)+sq]
+}
+
+// castleKey is the Zobrist key of the castling rights.
+func castleKey(rights int) uint64 {
+	var key uint64
+	for i := uint(0); i < 4; i++ {
+		if rights&(1<<i) != 0 {
+			key ^= polyglotRandom[768+i]
+		}
+	}
+	return key
+}
+
+// stateKey is the Zobrist key of everything but the
+// pieces. En passant only counts if a pawn can take.
+func (p *Position) stateKey() uint64 {
+	key := castleKey(p.castle)
+	if p.ep != NoSquare && pawnAttacks[1-p.side][p.ep]&p.pieces[p.side][Pawn] != 0 {
+		key ^= polyglotRandom[772+p.ep%8]
+	}
+	if p.side == White {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+// repeated returns true if the position stood before,
+// since the last capture or pawn move.
+func (p *Position) repeated() bool {
+	n := len(p.undos)
+	for i := n - 2; i >= 0 && i >= n-p.halfmove; i -= 2 {
+		if p.undos[i].hash == p.hash {
+			return true
+		}
+	}
+	return false
+}
+
+// put places piece pc on sq, which must be empty.
+func (p *Position) put(sq, pc int) {
+	p.board[sq] = pc
+	p.pieces[pc/6][pc%6] |= 1 << uint(sq)
+	p.occupied[pc/6] |= 1 << uint(sq)
+	p.hash ^= pieceKey(pc, sq)
+}
+
+// remove takes the piece off sq.
+func (p *Position) remove(sq int) {
+	pc := p.board[sq]
+	p.hash ^= pieceKey(pc, sq)
+	p.board[sq] = Empty
+	p.pieces[pc/6][pc%6] &^= 1 << uint(sq)
+	p.occupied[pc/6] &^= 1 << uint(sq)
+}
+
+// ParseFen sets up a Position from a FEN, the clocks
+// may be left out.
+func ParseFen(fen string) (*Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, errInvalidFen
+	}
+	p := &Position{ep: NoSquare, fullmove: 1}
+	for i := range p.board {
+		p.board[i] = Empty
+	}
+	rank, file := 7, 0
+	for _, c := range fields[0] {
+		switch {
+		case c == '/':
+			rank--
+			file = 0
+		case c >= '1' && c <= '8':
+			file += int(c - '0')
+		default:
+			pc := strings.IndexRune(pieceLetters, c)
+			if pc < 0 || file > 7 || rank < 0 {
+				return nil, errInvalidFen
+			}
+			p.put(rank*8+file, pc)
+			file++
+		}
+	}
+	if bits.OnesCount64(p.pieces[White][King]) != 1 ||
+		bits.OnesCount64(p.pieces[Black][King]) != 1 {
+		return nil, errors.New("Invalid FEN, one king each")
+	}
+	switch fields[1] {
+	case "w":
+		p.side = White
+	case "b":
+		p.side = Black
+	default:
+		return nil, errInvalidFen
+	}
+	// Rights without their king and rook are dropped,
+	// as are ghess's '-' placeholders
+	rights := map[rune]struct{ right, king, rook int }{
+		'K': {castleWK, 4, 7}, 'Q': {castleWQ, 4, 0},
+		'k': {castleBK, 60, 63}, 'q': {castleBQ, 60, 56},
+	}
+	for _, c := range fields[2] {
+		r, ok := rights[c]
+		if !ok {
+			continue
+		}
+		colour := White
+		if c >= 'a' {
+			colour = Black
+		}
+		if p.board[r.king] == colour*6+King && p.board[r.rook] == colour*6+Rook {
+			p.castle |= r.right
+		}
+	}
+	if fields[3] != "-" {
+		sq, err := parseSquare(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		p.ep = sq
+	}
+	if len(fields) > 4 {
+		p.halfmove, _ = strconv.Atoi(fields[4])
+	}
+	if len(fields) > 5 {
+		n, err := strconv.Atoi(fields[5])
+		if err == nil && n > 0 {
+			p.fullmove = n
+		}
+	}
+	p.hash ^= p.stateKey()
+	return p, nil
+}
+
+// Fen writes the position as a standard FEN.
+func (p *Position) Fen() string {
+	var b strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		emptyRun := 0
+		for file := 0; file < 8; file++ {
+			pc := p.board[rank*8+file]
+			if pc == Empty {
+				emptyRun++
+				continue
+			}
+			if emptyRun > 0 {
+				b.WriteString(strconv.Itoa(emptyRun))
+				emptyRun = 0
+			}
+			b.WriteByte(pieceLetters[pc])
+		}
+		if emptyRun > 0 {
+			b.WriteString(strconv.Itoa(emptyRun))
+		}
+		if rank > 0 {
+			b.WriteByte('/')
+		}
+	}
+	side := "w"
+	if p.side == Black {
+		side = "b"
+	}
+	castle := ""
+	for i, c := range "KQkq" {
+		if p.castle&(1<<uint(i)) != 0 {
+			castle += string(c)
+		}
+	}
+	if castle == "" {
+		castle = "-"
+	}
+	ep := "-"
+	if p.ep != NoSquare {
+		ep = squareName(p.ep)
+	}
+	return fmt.Sprintf("%s %s %s %s %d %d", b.String(), side, castle, ep,
+		p.halfmove, p.fullmove)
+}
+
+// positionOf converts a ghess Board.
+func positionOf(g *ghess.Board) (*Position, error) {
+	return ParseFen(standardFen(g))
+}
+
+// Ghess converts the position back to a ghess Board.
+// Ghess forgets the en passant square and the clocks.
+func (p *Position) Ghess() (ghess.Board, error) {
+	return loadFen(p.Fen())
+}
+
+// parseSquare reads a square name such as "e4".
+func parseSquare(name string) (int, error) {
+	if len(name) != 2 || name[0] < 'a' || name[0] > 'h' ||
+		name[1] < '1' || name[1] > '8' {
+		return NoSquare, errors.New("Invalid square " + name)
+	}
+	return int(name[1]-'1')*8 + int(name[0]-'a'), nil
+}
+
+// squareName writes a square such as "e4".
+func squareName(sq int) string {
+	return string([]byte{byte('a' + sq%8), byte('1' + sq/8)})
+}
+
+// ghessSquare converts a square to ghess coordinates.
+func ghessSquare(sq int) int {
+	return (sq/8+1)*10 + 8 - sq%8
+}
+
+// squareOf converts ghess coordinates to a square.
+func squareOf(coord int) int {
+	return (coord/10-1)*8 + 8 - coord%10
+}
+
+// kingSquare returns where the king of colour stands.
+func (p *Position) kingSquare(colour int) int {
+	return bits.TrailingZeros64(p.pieces[colour][King])
+}
+
+// attacked returns true if colour by attacks sq.
+func (p *Position) attacked(sq, by int) bool {
+	if pawnAttacks[1-by][sq]&p.pieces[by][Pawn] != 0 ||
+		knightAttacks[sq]&p.pieces[by][Knight] != 0 ||
+		kingAttacks[sq]&p.pieces[by][King] != 0 {
+		return true
+	}
+	occ := p.occupied[White] | p.occupied[Black]
+	diagonal := p.pieces[by][Bishop] | p.pieces[by][Queen]
+	if diagonal != 0 && slide(sq, occ, bishopDirs)&diagonal != 0 {
+		return true
+	}
+	straight := p.pieces[by][Rook] | p.pieces[by][Queen]
+	return straight != 0 && slide(sq, occ, rookDirs)&straight != 0
+}
+
+// inCheck returns true if the side to move is in check.
+func (p *Position) inCheck() bool {
+	return p.attacked(p.kingSquare(p.side), 1-p.side)
+}
+
+// castleRook returns where the rook of a castle to
+// king square to starts and ends.
+func castleRook(to int) (int, int) {
+	switch to {
+	case 6:
+		return 7, 5
+	case 2:
+		return 0, 3
+	case 62:
+		return 63, 61
+	}
+	return 56, 59
+}
+
+// makeMove plays m, which must be pseudo legal.
+func (p *Position) makeMove(m move) {
+	from, to := m.from(), m.to()
+	us := p.side
+	pc := p.board[from]
+	u := undo{m: m, captured: p.board[to], castle: p.castle,
+		ep: p.ep, halfmove: p.halfmove, hash: p.hash}
+	p.undos = append(p.undos, u)
+	p.hash ^= p.stateKey()
+
+	p.halfmove++
+	if pc%6 == Pawn || u.captured != Empty {
+		p.halfmove = 0
+	}
+	if u.captured != Empty {
+		p.remove(to)
+	}
+	p.remove(from)
+	p.put(to, pc)
+	switch m.flag() {
+	case flagEnPassant:
+		if us == White {
+			p.remove(to - 8)
+		} else {
+			p.remove(to + 8)
+		}
+	case flagCastle:
+		rookFrom, rookTo := castleRook(to)
+		p.remove(rookFrom)
+		p.put(rookTo, us*6+Rook)
+	}
+	if promo := m.promotion(); promo != 0 {
+		p.remove(to)
+		p.put(to, us*6+promo)
+	}
+	p.ep = NoSquare
+	if m.flag() == flagDouble {
+		p.ep = (from + to) / 2
+	}
+	p.castle &= castleKeep[from] & castleKeep[to]
+	if us == Black {
+		p.fullmove++
+	}
+	p.side = 1 - us
+	p.hash ^= p.stateKey()
+}
+
+// unmakeMove takes back the last move made.
+func (p *Position) unmakeMove() {
+	u := p.undos[len(p.undos)-1]
+	p.undos = p.undos[:len(p.undos)-1]
+	p.side = 1 - p.side
+	us := p.side
+	from, to := u.m.from(), u.m.to()
+	if u.m.promotion() != 0 {
+		p.remove(to)
+		p.put(to, us*6+Pawn)
+	}
+	pc := p.board[to]
+	p.remove(to)
+	p.put(from, pc)
+	switch u.m.flag() {
+	case flagEnPassant:
+		if us == White {
+			p.put(to-8, Black*6+Pawn)
+		} else {
+			p.put(to+8, White*6+Pawn)
+		}
+	case flagCastle:
+		rookFrom, rookTo := castleRook(to)
+		p.remove(rookTo)
+		p.put(rookFrom, us*6+Rook)
+	}
+	if u.captured != Empty {
+		p.put(to, u.captured)
+	}
+	p.castle, p.ep, p.halfmove = u.castle, u.ep, u.halfmove
+	p.hash = u.hash
+	if us == Black {
+		p.fullmove--
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZobristKeys(t *testing.T) {
+	for _, c := range polyglotKeys {
+		p, err := ParseFen(c.Fen)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Fen, err)
+		}
+		if p.hash != c.Key {
+			t.Errorf("%s: key %016x, want %016x", c.Fen, p.hash, c.Key)
+		}
+	}
+}
+
+// Keys kept up by makeMove are the keys of the
+// positions reached.
+func TestZobristMoves(t *testing.T) {
+	for _, c := range polyglotKeys {
+		p, err := ParseFen(startFen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, uci := range strings.Fields(c.Moves) {
+			found := false
+			for _, m := range p.legalMoves(nil) {
+				if m.String() == uci {
+					p.makeMove(m)
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Fatalf("%s: no move %s", c.Moves, uci)
+			}
+			q, err := ParseFen(p.Fen())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.hash != q.hash {
+				t.Errorf("%s: %s key %016x, Fen key %016x", c.Moves, uci, p.hash, q.hash)
+			}
+		}
+		if p.hash != c.Key {
+			t.Errorf("%s: key %016x, want %016x", c.Moves, p.hash, c.Key)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/polypmer/ghess"
+)
+
+// Book is a Polyglot opening book, a .bin file of
+// 16 byte entries sorted by position key.
+type Book struct {
+	Name    string
+	entries []bookEntry
+}
+
+// bookEntry is a move of a Polyglot book.
+type bookEntry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+// books are the opening books loaded with -book,
+// consulted in order.
+var books []*Book
+
+// bookDepth is how many plies into the game each
+// difficulty plays from the books, set with -bookdepth.
+var bookDepth = map[int]int{
+	3: 8,
+	4: 12,
+	5: 20,
+}
+
+// LoadBook reads a Polyglot book from disk.
+func LoadBook(path string) (*Book, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%16 != 0 {
+		return nil, errors.New("Invalid Polyglot book " + path)
+	}
+	b := &Book{Name: path, entries: make([]bookEntry, len(data)/16)}
+	for i := range b.entries {
+		e := data[i*16 : i*16+16]
+		b.entries[i] = bookEntry{
+			Key:    binary.BigEndian.Uint64(e[0:8]),
+			Move:   binary.BigEndian.Uint16(e[8:10]),
+			Weight: binary.BigEndian.Uint16(e[10:12]),
+			Learn:  binary.BigEndian.Uint32(e[12:16]),
+		}
+	}
+	// Books are sorted already, but don't trust them
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return b.entries[i].Key < b.entries[j].Key
+	})
+	return b, nil
+}
+
+// LoadBooks reads the comma separated list of
+// books into books.
+func LoadBooks(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		if path == "" {
+			continue
+		}
+		b, err := LoadBook(path)
+		if err != nil {
+			return err
+		}
+		books = append(books, b)
+	}
+	return nil
+}
+
+// ParseBookDepth reads a -bookdepth list such as
+// "3=8,4=12,5=20" into bookDepth.
+func ParseBookDepth(list string) error {
+	for _, pair := range strings.Split(list, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			return errors.New("Invalid book depth " + pair)
+		}
+		diff, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return err
+		}
+		plies, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return err
+		}
+		bookDepth[diff] = plies
+	}
+	return nil
+}
+
+// Moves returns the entries of the book for a position key.
+func (b *Book) Moves(key uint64) []bookEntry {
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return b.entries[i].Key >= key
+	})
+	j := i
+	for j < len(b.entries) && b.entries[j].Key == key {
+		j++
+	}
+	return b.entries[i:j]
+}
+
+// polyglotPiece is the kind of piece in the Polyglot
+// Random64 array, black pawn is 0 and white king 11.
+var polyglotPiece = map[byte]int{
+	'p': 0, 'P': 1, 'n': 2, 'N': 3, 'b': 4, 'B': 5,
+	'r': 6, 'R': 7, 'q': 8, 'Q': 9, 'k': 10, 'K': 11,
+}
+
+// polyglotKey returns the Polyglot Zobrist key of
+// the position of g.
+func polyglotKey(g *ghess.Board) uint64 {
+	var key uint64
+	arr := boardOf(g)
+	for rank := 1; rank <= 8; rank++ {
+		for file := 0; file < 8; file++ {
+			p := arr[rank*10+8-file]
+			kind, ok := polyglotPiece[p]
+			if !ok {
+				continue
+			}
+			key ^= polyglotRandom[64*kind+8*(rank-1)+file]
+		}
+	}
+	fields := strings.Split(g.Position(), " ")
+	for _, c := range fields[2] {
+		switch c {
+		case 'K':
+			key ^= polyglotRandom[768]
+		case 'Q':
+			key ^= polyglotRandom[769]
+		case 'k':
+			key ^= polyglotRandom[770]
+		case 'q':
+			key ^= polyglotRandom[771]
+		}
+	}
+	// En passant only counts if a pawn can take it
+	if ep, ok := ghess.PgnToCoordMap[fields[3]]; ok {
+		pawn, behind := byte('P'), -10
+		if fields[1] == "b" {
+			pawn, behind = 'p', 10
+		}
+		// The capturing pawns stand beside the pawn
+		// which moved, one rank away from the target
+		if arr[ep+behind-1] == pawn || arr[ep+behind+1] == pawn {
+			file := int(fields[3][0] - 'a')
+			key ^= polyglotRandom[772+file]
+		}
+	}
+	if fields[1] == "w" {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+// decodeBookMove turns a Polyglot move into ghess
+// coordinates. Castles are the king onto the rook,
+// as in ghess. Underpromotions aren't supported.
+func decodeBookMove(mv uint16) (int, int, error) {
+	toFile := int(mv & 7)
+	toRow := int(mv>>3) & 7
+	fromFile := int(mv>>6) & 7
+	fromRow := int(mv>>9) & 7
+	promotion := int(mv>>12) & 7
+	if promotion != 0 && promotion != 4 {
+		return 0, 0, errors.New("Only queen promotions")
+	}
+	orig := (fromRow+1)*10 + 8 - fromFile
+	dest := (toRow+1)*10 + 8 - toFile
+	return orig, dest, nil
+}
+
+// bookMove picks a weighted random book move for g
+// from all the books. It returns false if none of the
+// books knows the position.
+func bookMove(g *ghess.Board) (int, int, bool) {
+	return pickBookMove(books, g)
+}
+
+// pickBookMove is bookMove from the books bs.
+func pickBookMove(bs []*Book, g *ghess.Board) (int, int, bool) {
+	if len(bs) < 1 {
+		return 0, 0, false
+	}
+	key := polyglotKey(g)
+	var candidates []bookEntry
+	var total int
+	for _, b := range bs {
+		for _, e := range b.Moves(key) {
+			orig, dest, err := decodeBookMove(e.Move)
+			if err != nil {
+				continue
+			}
+			// Never trust a book with legality
+			possible := ghess.CopyBoard(g)
+			if possible.Move(orig, dest) != nil {
+				continue
+			}
+			candidates = append(candidates, e)
+			total += int(e.Weight)
+		}
+	}
+	if len(candidates) < 1 {
+		return 0, 0, false
+	}
+	pick := candidates[rand.Intn(len(candidates))]
+	if total > 0 {
+		n := rand.Intn(total)
+		for _, e := range candidates {
+			n -= int(e.Weight)
+			if n < 0 {
+				pick = e
+				break
+			}
+		}
+	}
+	orig, dest, _ := decodeBookMove(pick.Move)
+	return orig, dest, true
+}
+
+// inBook returns true if a game of diff at ply
+// should still play from the books.
+func inBook(diff, ply int) bool {
+	return ply < bookDepth[diff]
+}
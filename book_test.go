@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/polypmer/ghess"
+)
+
+// polyglotKeys are the reference positions of the
+// Polyglot book format, by the moves from the start.
+var polyglotKeys = []struct {
+	Moves string
+	Fen   string
+	Key   uint64
+}{
+	{"", startFen, 0x463b96181691fc9c},
+	{"e2e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", 0x823c9b50fd114196},
+	{"e2e4 d7d5", "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", 0x0756b94461c50fb0},
+	{"e2e4 d7d5 e4e5", "rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2", 0x662fafb965db29d4},
+	{"e2e4 d7d5 e4e5 f7f5", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", 0x22a48b5a8e47ff78},
+	{"e2e4 d7d5 e4e5 f7f5 e1e2", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR b kq - 0 3", 0x652a607ca3f242c1},
+	{"e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", "rnbq1bnr/ppp1pkpp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR w - - 0 4", 0x00fdd303c946bdd9},
+	{"a2a4 b7b5 h2h4 b5b4 c2c4", "rnbqkbnr/p1pppppp/8/8/PpP4P/8/1P1PPPP1/RNBQKBNR b KQkq c3 0 3", 0x3c8123ea7b067637},
+	{"a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", "rnbqkbnr/p1pppppp/8/8/P6P/R1p5/1P1PPPP1/1NBQKBNR b Kkq - 0 4", 0x5c3f9b829b279560},
+}
+
+// ghess drops the en passant square of a loaded Fen,
+// so the book keys are of games played to the position.
+func TestBookKeys(t *testing.T) {
+	for _, c := range polyglotKeys {
+		game := ghess.NewBoard()
+		for _, uci := range strings.Fields(c.Moves) {
+			err := game.Move(ghess.PgnToCoordMap[uci[:2]], ghess.PgnToCoordMap[uci[2:]])
+			if err != nil {
+				t.Fatalf("%s: %s %s", c.Moves, uci, err)
+			}
+		}
+		if key := polyglotKey(&game); key != c.Key {
+			t.Errorf("%s: book key %016x, want %016x", c.Moves, key, c.Key)
+		}
+	}
+}
+
+func TestDecodeBookMove(t *testing.T) {
+	cases := []struct {
+		mv         uint16
+		orig, dest string
+	}{
+		{12<<6 | 28, "e2", "e4"},
+		{4<<6 | 7, "e1", "h1"},           // castles onto the rook
+		{4<<12 | 52<<6 | 60, "e7", "e8"}, // queen promotion
+	}
+	for _, c := range cases {
+		orig, dest, err := decodeBookMove(c.mv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orig != ghess.PgnToCoordMap[c.orig] || dest != ghess.PgnToCoordMap[c.dest] {
+			t.Errorf("%d: %d %d, want %s%s", c.mv, orig, dest, c.orig, c.dest)
+		}
+	}
+	if _, _, err := decodeBookMove(1<<12 | 52<<6 | 60); err == nil {
+		t.Error("knight promotion decoded")
+	}
+}
+
+// writeBook writes a Polyglot book of entries,
+// unsorted, into dir.
+func writeBook(t *testing.T, dir string, entries []bookEntry) string {
+	data := make([]byte, 16*len(entries))
+	for i, e := range entries {
+		binary.BigEndian.PutUint64(data[i*16:], e.Key)
+		binary.BigEndian.PutUint16(data[i*16+8:], e.Move)
+		binary.BigEndian.PutUint16(data[i*16+10:], e.Weight)
+		binary.BigEndian.PutUint32(data[i*16+12:], e.Learn)
+	}
+	path := filepath.Join(dir, "test.bin")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBookMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "growser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	start := polyglotKeys[0].Key
+	path := writeBook(t, dir, []bookEntry{
+		{Key: polyglotKeys[1].Key, Move: 51<<6 | 35, Weight: 1}, // d7d5
+		{Key: start, Move: 12<<6 | 28, Weight: 1},               // e2e4
+		{Key: start, Move: 12<<6 | 36, Weight: 100},             // e2e5 is illegal
+	})
+	b, err := LoadBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.Moves(start)); n != 2 {
+		t.Fatalf("%d moves from the start, want 2", n)
+	}
+	defer func(saved []*Book) { books = saved }(books)
+	books = []*Book{b}
+	game := ghess.NewBoard()
+	for i := 0; i < 20; i++ {
+		orig, dest, ok := bookMove(&game)
+		if !ok || orig != ghess.PgnToCoordMap["e2"] || dest != ghess.PgnToCoordMap["e4"] {
+			t.Fatalf("book move %d %d %v, want e2e4", orig, dest, ok)
+		}
+	}
+	game.Move(ghess.PgnToCoordMap["a2"], ghess.PgnToCoordMap["a3"])
+	if _, _, ok := bookMove(&game); ok {
+		t.Error("book move out of the book")
+	}
+}
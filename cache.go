@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/polypmer/ghess"
+)
+
+var (
+	// cacheBucket holds searched moves by position and settings.
+	cacheBucket = []byte("enginecache")
+	// cacheUsedBucket orders the cache keys by when they
+	// were last used, the key being the time then the
+	// cache key, for evicting the least recently used.
+	cacheUsedBucket = []byte("enginecacheused")
+)
+
+// defaultCacheSize is how many moves the engine cache
+// keeps unless configured.
+const defaultCacheSize = 100000
+
+// cacheFlushTime is how often the cache writes when
+// its moves were last used.
+const cacheFlushTime = time.Minute
+
+// engineCache is consulted before searching, nil
+// without a database.
+var engineCache *EngineCache
+
+// EngineCache keeps the moves of finished searches in
+// bolt, so positions many games reach are only searched
+// once for each difficulty.
+type EngineCache struct {
+	limit   int
+	weights string // tells evaluations apart
+
+	// Hits are kept here until the next flush, rather
+	// than each writing, the cache key to when it was
+	// last used.
+	mu      sync.Mutex
+	touched map[string]int64
+
+	// Statistics, set atomically
+	entries   int64
+	hits      int64
+	misses    int64
+	stores    int64
+	evictions int64
+}
+
+// cacheEntry is a cached search, stored as json.
+type cacheEntry struct {
+	Orig  int   `json:"orig"`
+	Dest  int   `json:"dest"`
+	Score int   `json:"score"`
+	Depth int   `json:"depth"`
+	Used  int64 `json:"used"` // unix nano, its key in cacheUsedBucket
+}
+
+// CacheStats are what operators see of the cache.
+type CacheStats struct {
+	Entries   int64   `json:"entries"`
+	Limit     int     `json:"limit"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hitRate"`
+	Stores    int64   `json:"stores"`
+	Evictions int64   `json:"evictions"`
+}
+
+// NewEngineCache makes the buckets of a cache of at
+// most limit moves, in db, for evalWeights.
+func NewEngineCache(limit int) (*EngineCache, error) {
+	c := &EngineCache{limit: limit, touched: make(map[string]int64)}
+	data, err := json.Marshal(evalWeights)
+	if err != nil {
+		return nil, err
+	}
+	c.weights = fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(cacheBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(cacheUsedBucket)
+		if err != nil {
+			return err
+		}
+		c.entries = int64(bucket.Stats().KeyN)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// key is the Zobrist key of g's position then the
+// settings diff searches with: its engine, and the
+// weights when it's growser's.
+func (c *EngineCache) key(g *ghess.Board, diff int) ([]byte, error) {
+	p, err := positionOf(g)
+	if err != nil {
+		return nil, err
+	}
+	e := engineFor(diff)
+	settings := fmt.Sprintf("%T", e)
+	if _, ok := e.(NegamaxEngine); ok {
+		settings += " " + c.weights
+	}
+	key := make([]byte, 8, 8+len(settings))
+	binary.BigEndian.PutUint64(key, p.hash)
+	return append(key, settings...), nil
+}
+
+// usedKey is the key of a cache key in cacheUsedBucket.
+func usedKey(used int64, key []byte) []byte {
+	k := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(used))
+	return append(k, key...)
+}
+
+// Get returns the cached move of g at diff, if it was
+// searched to depth or deeper and is legal in g.
+func (c *EngineCache) Get(g *ghess.Board, diff, depth int) (Result, bool) {
+	key, err := c.key(g, diff)
+	if err != nil {
+		return Result{}, false
+	}
+	var e cacheEntry
+	found := false
+	err = db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket(cacheBucket).Get(key)
+		if val == nil {
+			return nil
+		}
+		err := json.Unmarshal(val, &e)
+		found = err == nil && e.Depth >= depth
+		return err
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	// A Zobrist key may clash, the move must be legal
+	if !found || ghess.CopyBoard(g).Move(e.Orig, e.Dest) != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return Result{}, false
+	}
+	c.touch(key)
+	atomic.AddInt64(&c.hits, 1)
+	return Result{Orig: e.Orig, Dest: e.Dest, Score: e.Score, Depth: e.Depth}, true
+}
+
+// touch makes key the most recently used, in memory
+// until the next flush.
+func (c *EngineCache) touch(key []byte) {
+	c.mu.Lock()
+	c.touched[string(key)] = time.Now().UnixNano()
+	c.mu.Unlock()
+}
+
+// Flush writes when the cached moves were last used.
+func (c *EngineCache) Flush() {
+	err := db.Update(c.flush)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// flushEvery flushes the cache every d, forever.
+func (c *EngineCache) flushEvery(d time.Duration) {
+	for range time.Tick(d) {
+		c.Flush()
+	}
+}
+
+// flush writes the touched keys in tx, unless a Put
+// replaced their entries since they were used.
+func (c *EngineCache) flush(tx *bolt.Tx) error {
+	c.mu.Lock()
+	touched := c.touched
+	c.touched = make(map[string]int64)
+	c.mu.Unlock()
+	bucket := tx.Bucket(cacheBucket)
+	used := tx.Bucket(cacheUsedBucket)
+	for k, when := range touched {
+		key := []byte(k)
+		var e cacheEntry
+		val := bucket.Get(key)
+		if val == nil || json.Unmarshal(val, &e) != nil || e.Used >= when {
+			continue
+		}
+		err := used.Delete(usedKey(e.Used, key))
+		if err != nil {
+			return err
+		}
+		e.Used = when
+		err = used.Put(usedKey(e.Used, key), nil)
+		if err != nil {
+			return err
+		}
+		val, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(key, val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put caches the move of g at diff, unless a deeper
+// search is cached, evicting the least recently used
+// moves past the limit, after flushing the hits.
+func (c *EngineCache) Put(g *ghess.Board, diff int, res Result) {
+	if res.Orig == 0 || res.Depth < 1 {
+		return // only growser's search tells its depth
+	}
+	key, err := c.key(g, diff)
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := c.flush(tx)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(cacheBucket)
+		used := tx.Bucket(cacheUsedBucket)
+		var old cacheEntry
+		if val := bucket.Get(key); val != nil {
+			if json.Unmarshal(val, &old) == nil && old.Depth > res.Depth {
+				return nil
+			}
+			err := used.Delete(usedKey(old.Used, key))
+			if err != nil {
+				return err
+			}
+		} else {
+			atomic.AddInt64(&c.entries, 1)
+		}
+		e := cacheEntry{Orig: res.Orig, Dest: res.Dest, Score: res.Score,
+			Depth: res.Depth, Used: time.Now().UnixNano()}
+		val, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(key, val)
+		if err != nil {
+			return err
+		}
+		err = used.Put(usedKey(e.Used, key), nil)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.stores, 1)
+		// Evict the oldest, the used keys sort by time
+		cur := used.Cursor()
+		for k, _ := cur.First(); k != nil &&
+			atomic.LoadInt64(&c.entries) > int64(c.limit); k, _ = cur.First() {
+			err = bucket.Delete(k[8:])
+			if err != nil {
+				return err
+			}
+			err = cur.Delete()
+			if err != nil {
+				return err
+			}
+			atomic.AddInt64(&c.entries, -1)
+			atomic.AddInt64(&c.evictions, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Stats returns the size and use of the cache.
+func (c *EngineCache) Stats() CacheStats {
+	s := CacheStats{
+		Entries:   atomic.LoadInt64(&c.entries),
+		Limit:     c.limit,
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Stores:    atomic.LoadInt64(&c.stores),
+		Evictions: atomic.LoadInt64(&c.evictions),
+	}
+	if s.Hits+s.Misses > 0 {
+		s.HitRate = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	return s
+}
+
+// cachedMove is searchMove, consulting engineCache
+// first and filling it after. Weakened searches vary
+// on purpose, so they aren't cached.
+func cachedMove(g *ghess.Board, diff int, l Limits) (Result, error) {
+	if engineCache == nil || l.Human != nil {
+		return searchMove(g, diff, l)
+	}
+	if res, ok := engineCache.Get(g, diff, l.Depth); ok {
+		return res, nil
+	}
+	res, err := searchMove(g, diff, l)
+	if err == nil {
+		engineCache.Put(g, diff, res)
+	}
+	return res, err
+}
+
+// JSON statistics of the engine cache, for operators:
+// requests must carry "Authorization: Bearer" and the
+// OPERATOR_TOKEN of the environment.
+func EngineCacheStats(w http.ResponseWriter,
+	r *http.Request) {
+	token := os.Getenv("OPERATOR_TOKEN")
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	var s CacheStats
+	if engineCache != nil {
+		s = engineCache.Stats()
+	}
+	js, err := json.Marshal(s)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/polypmer/ghess"
+)
+
+// openTestDb points db at a new database for a test.
+func openTestDb(t *testing.T) {
+	t.Helper()
+	blt, err := bolt.Open(filepath.Join(t.TempDir(), "games.db"), 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	db = blt
+	t.Cleanup(func() {
+		blt.Close()
+		db = old
+	})
+}
+
+func TestEngineCache(t *testing.T) {
+	openTestDb(t)
+	c, err := NewEngineCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var games [3]ghess.Board
+	for i, mv := range []string{"e2e4", "d2d4", "g1f3"} {
+		games[i] = ghess.NewBoard()
+		orig, dest := ghess.PgnToCoordMap[mv[:2]], ghess.PgnToCoordMap[mv[2:]]
+		if err := games[i].Move(orig, dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// e7e5 is legal after each of them
+	res := Result{Orig: ghess.PgnToCoordMap["e7"], Dest: ghess.PgnToCoordMap["e5"], Depth: 3}
+	c.Put(&games[0], 0, res)
+	c.Put(&games[1], 0, res)
+	if _, ok := c.Get(&games[0], 0, 4); ok {
+		t.Error("a shallower search was used")
+	}
+	if got, ok := c.Get(&games[0], 0, 3); !ok || got.Orig != res.Orig || got.Dest != res.Dest {
+		t.Errorf("got %+v %v, want %+v", got, ok, res)
+	}
+	// games[0] was used last, so games[1] goes
+	c.Put(&games[2], 0, res)
+	if _, ok := c.Get(&games[1], 0, 1); ok {
+		t.Error("the least recently used move was kept")
+	}
+	if _, ok := c.Get(&games[0], 0, 1); !ok {
+		t.Error("the most recently used move was evicted")
+	}
+	s := c.Stats()
+	if s.Entries != 2 || s.Hits != 2 || s.Misses != 2 || s.Evictions != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// A hit writes nothing until the cache is flushed.
+func TestEngineCacheFlush(t *testing.T) {
+	openTestDb(t)
+	c, err := NewEngineCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game := ghess.NewBoard()
+	res := Result{Orig: ghess.PgnToCoordMap["e2"], Dest: ghess.PgnToCoordMap["e4"], Depth: 3}
+	c.Put(&game, 0, res)
+	used := func() []byte {
+		var k []byte
+		db.View(func(tx *bolt.Tx) error {
+			k, _ = tx.Bucket(cacheUsedBucket).Cursor().First()
+			k = append([]byte(nil), k...)
+			return nil
+		})
+		return k
+	}
+	before := used()
+	if _, ok := c.Get(&game, 0, 3); !ok {
+		t.Fatal("no cached move")
+	}
+	if !bytes.Equal(used(), before) {
+		t.Error("a hit was written before the flush")
+	}
+	c.Flush()
+	if bytes.Equal(used(), before) {
+		t.Error("the flush didn't write the hit")
+	}
+}
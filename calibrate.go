@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/polypmer/ghess"
+)
+
+// calibration is the measured rating of a level.
+type calibration struct {
+	Level  int
+	Rating float64
+	Margin float64 // 95%, chained from the anchor
+	// Against is the next level up, which it scored
+	// Score against in Games.
+	Against int
+	Games   int
+	Score   float64
+}
+
+// calibrate rates the levels by playing each against
+// the next one up, games games apiece, and chaining
+// the Elo differences down from the strongest level
+// taken as anchor.
+func calibrate(moveTime string, games, concurrency, maxPlies int, anchor float64,
+	progress func(weak, strong, wins, draws, losses int)) ([]calibration, error) {
+	var ls []int
+	for level := range levels {
+		ls = append(ls, level)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ls)))
+	starts := make([]ghess.Board, 0, len(matchOpenings))
+	for _, line := range matchOpenings {
+		g, err := loadOpening(line)
+		if err != nil {
+			return nil, err
+		}
+		starts = append(starts, g)
+	}
+	cals := []calibration{{Level: ls[0], Rating: anchor}}
+	for i := 1; i < len(ls); i++ {
+		weak, strong := ls[i], ls[i-1]
+		var players [2]*matchPlayer
+		for j, level := range []int{weak, strong} {
+			p, err := parseMatchPlayer(fmt.Sprintf("level=%d,time=%s", level, moveTime), concurrency)
+			if err != nil {
+				return nil, err
+			}
+			players[j] = p
+		}
+		var wins, draws, losses int
+		playMatch(players, starts, games, concurrency, maxPlies, func(m matchGame) {
+			switch m.score() {
+			case 1:
+				wins++
+			case 0.5:
+				draws++
+			default:
+				losses++
+			}
+			if progress != nil {
+				progress(weak, strong, wins, draws, losses)
+			}
+		})
+		for _, p := range players {
+			p.Engine.Close()
+		}
+		s, elo, margin := matchStats(wins, draws, losses)
+		above := cals[len(cals)-1]
+		cals = append(cals, calibration{Level: weak, Rating: above.Rating + elo,
+			Margin:  math.Hypot(above.Margin, margin),
+			Against: strong, Games: games, Score: s})
+	}
+	return cals, nil
+}
+
+// CalibrateCommand runs "growser calibrate", measuring
+// the ratings of the levels, see levels.
+func CalibrateCommand(args []string) {
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	games := flags.Int("games", 200, "games between each level and the next")
+	moveTime := flags.String("time", "300ms", "longest a move takes")
+	concurrency := flags.Int("concurrency", runtime.NumCPU(), "games played at once")
+	maxPlies := flags.Int("maxplies", 200, "plies before a game is drawn")
+	anchor := flags.Float64("anchor", 1600, "rating of the strongest level")
+	flags.Parse(args)
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	cals, err := calibrate(*moveTime, *games, *concurrency, *maxPlies, *anchor,
+		func(weak, strong, wins, draws, losses int) {
+			fmt.Printf("\r%s vs %s: +%d =%d -%d", levelName(weak), levelName(strong),
+				wins, draws, losses)
+			if wins+draws+losses == *games {
+				fmt.Println()
+			}
+		})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, c := range cals {
+		fmt.Printf("%d %-8s %5.0f +/- %3.0f", c.Level, levelName(c.Level), c.Rating, c.Margin)
+		if c.Games > 0 {
+			fmt.Printf("  scored %.3f in %d games against %s", c.Score, c.Games,
+				levelName(c.Against))
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/polypmer/ghess"
+)
+
+// coachThreshold is how much evaluation, in centipawns,
+// a human move may lose before the coach speaks up.
+const coachThreshold = 150
+
+// coachDepth is how deep the coach searches to judge
+// a move, deep enough to see a piece left en prise.
+const coachDepth = 2
+
+// pieceNames for explaining moves in plain words.
+var pieceNames = map[byte]string{
+	'p': "pawn", 'n': "knight", 'b': "bishop",
+	'r': "rook", 'q': "queen", 'k': "king",
+}
+
+// pieceValues are the material values ghess evaluates with.
+var pieceValues = map[byte]int{
+	'p': 100, 'n': 320, 'b': 330, 'r': 500, 'q': 900, 'k': 20000,
+}
+
+// lower returns the lower case of a piece byte.
+func lower(p byte) byte {
+	if p >= 'A' && p <= 'Z' {
+		return p + 'a' - 'A'
+	}
+	return p
+}
+
+// boardOf returns the pieces of g indexed by ghess
+// coordinates, with '.' for empty squares. The
+// Board's own array isn't exported, so read the FEN.
+func boardOf(g *ghess.Board) [120]byte {
+	var arr [120]byte
+	for i := range arr {
+		arr[i] = ' '
+	}
+	fields := strings.Split(g.Position(), " ")
+	rank, file := 8, 0
+	for _, val := range fields[0] {
+		switch {
+		case val == '/':
+			rank--
+			file = 0
+		case val >= '1' && val <= '8':
+			for j := 0; j < int(val-'0'); j++ {
+				arr[rank*10+8-file] = '.'
+				file++
+			}
+		default:
+			arr[rank*10+8-file] = byte(val)
+			file++
+		}
+	}
+	return arr
+}
+
+// turnOf returns "w" or "b", whose move it is in g.
+func turnOf(g *ghess.Board) string {
+	return g.Stats()["turn"]
+}
+
+// isMine returns true if piece p belongs to the
+// player moving with sign, 1 for white -1 for black.
+func isMine(p byte, sign int) bool {
+	if p == '.' || p == ' ' {
+		return false
+	}
+	isWhite := p <= 'Z'
+	return isWhite == (sign == 1)
+}
+
+// hanging returns the squares of the pieces of the
+// player with sign which are attacked more than
+// they are defended, according to Tension.
+func hanging(arr [120]byte, tension map[int]int, sign int) map[int]bool {
+	h := make(map[int]bool)
+	for sq, p := range arr {
+		if !isMine(p, sign) || lower(p) == 'k' {
+			continue
+		}
+		if sign*tension[sq] < 0 {
+			h[sq] = true
+		}
+	}
+	return h
+}
+
+// explainMove describes in a few words what the move
+// orig to dest does for the player making it, from the
+// change in evaluation and the Tension of the boards.
+// It also returns the evaluation change for that player.
+func explainMove(g *ghess.Board, orig, dest int) (string, int) {
+	sign := 1
+	if turnOf(g) == "b" {
+		sign = -1
+	}
+	next := ghess.CopyBoard(g)
+	err := next.Move(orig, dest)
+	if err != nil {
+		return err.Error(), 0
+	}
+	delta := sign * (next.Evaluate() - g.Evaluate())
+	if next.Checkmate {
+		return "this is checkmate", delta
+	}
+	before, after := boardOf(g), boardOf(next)
+	wasHanging := hanging(before, g.Tension(), sign)
+	nowHanging := hanging(after, next.Tension(), sign)
+
+	notes := make([]string, 0)
+	// The most valuable newly hanging piece
+	var worst int
+	for sq := range nowHanging {
+		if wasHanging[sq] && sq != dest {
+			continue
+		}
+		if worst == 0 || pieceValues[lower(after[sq])] >
+			pieceValues[lower(after[worst])] {
+			worst = sq
+		}
+	}
+	captured := before[dest]
+	if isMine(captured, -sign) {
+		if worst == dest && pieceValues[lower(captured)] <
+			pieceValues[lower(after[dest])] {
+			notes = append(notes, "trades your "+
+				pieceNames[lower(after[dest])]+" for a "+
+				pieceNames[lower(captured)])
+		} else {
+			notes = append(notes, "wins a "+
+				pieceNames[lower(captured)])
+		}
+	}
+	if worst != 0 {
+		if worst == dest {
+			notes = append(notes, "this hangs your "+
+				pieceNames[lower(after[worst])])
+		} else {
+			notes = append(notes, "this leaves your "+
+				pieceNames[lower(after[worst])]+" hanging")
+		}
+	} else if wasHanging[orig] {
+		notes = append(notes, "saves your "+
+			pieceNames[lower(before[orig])])
+	}
+	if next.Check {
+		notes = append(notes, "gives check")
+	}
+	if len(notes) == 0 {
+		switch {
+		case delta > 0:
+			notes = append(notes, "improves your position")
+		case delta < 0:
+			notes = append(notes, "weakens your position")
+		default:
+			notes = append(notes, "keeps the balance")
+		}
+	}
+	return strings.Join(notes, ", "), delta
+}
+
+// moveLoss returns how many centipawns the move orig to
+// dest loses for the player making it, against the best
+// move in g. Like analyseGame it searches g, then the
+// position after the move a ply shallower.
+func moveLoss(g *ghess.Board, orig, dest int) (int, error) {
+	res, err := analysisEngine.BestMove(g, analysisLimits(coachDepth))
+	if err != nil {
+		return 0, err
+	}
+	if res.Orig == orig && res.Dest == dest {
+		return 0, nil
+	}
+	next := ghess.CopyBoard(g)
+	err = next.Move(orig, dest)
+	if err != nil {
+		return 0, err
+	}
+	if next.Checkmate {
+		return 0, nil
+	}
+	reply, err := analysisEngine.BestMove(next, analysisLimits(coachDepth-1))
+	if err != nil {
+		return 0, err
+	}
+	loss := res.Score + reply.Score
+	if loss < 0 {
+		loss = 0
+	}
+	return loss, nil
+}
+
+// Hint is the engine's suggestion for the human.
+type Hint struct {
+	Origin  string `json:"origin"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+	GameId  string `json:"id"`
+	Hints   int    `json:"hints"`
+	Error   bool   `json:"error"`
+}
+
+// AJAX call to ask the engine what to play
+func HintGame(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	diff, _ := strconv.Atoi(vars["diff"])
+	if diff < 1 {
+		diff = playerLevel(seatOf(r))
+	}
+	var pos string
+	var owner, rated bool
+	// Get game from DB
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(games)
+		if bucket == nil {
+			return errors.New("No bucket")
+		}
+		val := bucket.Get([]byte(id))
+		pos = string(val)
+		rec := getRecord(tx, id)
+		owner, rated = ownsGame(r, rec), rec.Rated
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !owner {
+		forbidGame(w, id, "")
+		return
+	}
+	// Set up board
+	game := ghess.NewBoard()
+	err = game.LoadFen(pos)
+	if err != nil {
+		fmt.Println(err)
+	}
+	hint := &Hint{GameId: id}
+	origs, _ := game.SearchValid()
+	if game.Checkmate || len(origs) < 1 {
+		hint.Message = "> There's nothing left to play"
+		hint.Error = true
+	} else if rated {
+		hint.Message = "> No hints in a rated game"
+		hint.Error = true
+	} else {
+		res, err := engineMove(&game, diff)
+		if err != nil {
+			fmt.Println("Engine broken", err)
+		}
+		why, _ := explainMove(&game, res.Orig, res.Dest)
+		hint.Origin = ghess.PieceMap[res.Orig]
+		hint.Target = ghess.PieceMap[res.Dest]
+		hint.Message = fmt.Sprintf("> Hint: try %s-%s,<br><br><i>%s</i>",
+			hint.Origin, hint.Target, why)
+		// Count the hint
+		err = db.Update(func(tx *bolt.Tx) error {
+			rec := getRecord(tx, id)
+			rec.Hints++
+			hint.Hints = rec.Hints
+			return putRecord(tx, rec)
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	js, err := json.Marshal(hint)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write([]byte(js))
+}
+
+// AJAX call to turn the coach on or off
+func CoachGame(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	on, _ := strconv.ParseBool(vars["on"])
+	var rec Record
+	found, owner := true, true
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, recs := tx.Bucket(games), tx.Bucket(records)
+		if bucket == nil || recs == nil ||
+			bucket.Get([]byte(id)) == nil || recs.Get([]byte(id)) == nil {
+			found = false
+			return nil
+		}
+		rec = getRecord(tx, id)
+		if !ownsGame(r, rec) {
+			owner = false
+			return nil
+		}
+		rec.Coach = on
+		return putRecord(tx, rec)
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if !owner {
+		forbidGame(w, id, "")
+		return
+	}
+	js, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write([]byte(js))
+}
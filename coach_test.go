@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/polypmer/ghess"
+)
+
+func TestExplainMove(t *testing.T) {
+	cases := []struct {
+		fen, orig, dest, want string
+	}{
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "f1", "a6", "hangs your bishop"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "e4", "d5", "wins a pawn"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - 0 2", "d8", "h4", "checkmate"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "g1", "f3", "improves your position"},
+	}
+	for _, c := range cases {
+		game := ghess.NewBoard()
+		if err := game.LoadFen(c.fen); err != nil {
+			t.Fatal(err)
+		}
+		why, _ := explainMove(&game, ghess.PgnToCoordMap[c.orig], ghess.PgnToCoordMap[c.dest])
+		if !strings.Contains(why, c.want) {
+			t.Errorf("%s%s: %q, want %q", c.orig, c.dest, why, c.want)
+		}
+	}
+}
+
+// The coach warns of a piece hung in one move, which
+// the static evaluation doesn't see.
+func TestCoachWarning(t *testing.T) {
+	openTestDb(t)
+	fen := "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"
+	router := mux.NewRouter()
+	router.HandleFunc("/play/{id}/{orig}/{dest}/{diff}", PlayGame)
+	cases := []struct {
+		orig, dest string
+		warn       bool
+	}{
+		{"f1", "a6", true},
+		{"g1", "f3", false},
+	}
+	for _, c := range cases {
+		err := db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(games)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte("1"), []byte(fen))
+			if err != nil {
+				return err
+			}
+			return putRecord(tx, Record{Id: "1", Kind: "ai", Start: fen,
+				White: guestPrefix + "me", Black: aiSeat, Coach: true})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/play/1/"+c.orig+"/"+c.dest+"/1", nil)
+		r = r.WithContext(context.WithValue(r.Context(), guestKey{}, "me"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		var mv Move
+		err = json.Unmarshal(w.Body.Bytes(), &mv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stopPonder("1")
+		if (mv.Coach != "") != c.warn {
+			t.Errorf("%s%s: coach %q, want warning %v", c.orig, c.dest, mv.Coach, c.warn)
+		}
+	}
+}
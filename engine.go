@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// Limits bound a search. Engines honour what they
+// can, ghess searches by Depth and UCI engines prefer
+// MoveTime when it's set.
+type Limits struct {
+	Depth    int
+	MoveTime time.Duration
+	Threads  int             // searching together, growser's only
+	Human    *Profile        // weakens growser's search, nil for none
+	Stop     <-chan struct{} // closed to stop early
+	Info     func(Info)      // told about every depth
+}
+
+// Result is the move an Engine found, as ghess
+// coordinates, and its score in centipawns for the
+// player to move. Orig is 0 when there's no move.
+// Pv, the expected line, and Depth, the depth searched,
+// are only growser's.
+type Result struct {
+	Orig  int
+	Dest  int
+	Score int
+	Depth int
+	Pv    [][2]int
+}
+
+// Engine chooses moves for the AI.
+type Engine interface {
+	// BestMove searches the position of g.
+	BestMove(g *ghess.Board, l Limits) (Result, error)
+	// Close releases what the Engine holds, eg processes.
+	Close() error
+}
+
+// MiniMaxEngine is ghess's own search, MiniMaxPruning
+// with its opening dictionary. Ghess doesn't tell the
+// score of its search, so the Score is the evaluation
+// right after the move. It's slow, copying Boards for
+// every node, but matches can still play against it.
+type MiniMaxEngine struct{}
+
+func (MiniMaxEngine) BestMove(g *ghess.Board, l Limits) (Result, error) {
+	res := Result{}
+	origs, _ := g.SearchValid()
+	if g.Checkmate || len(origs) < 1 {
+		return res, nil
+	}
+	state, err := ghess.MiniMaxPruning(0, l.Depth, ghess.GetState(g))
+	if err != nil {
+		return res, err
+	}
+	res.Orig, res.Dest = state.Init[0], state.Init[1]
+	next := ghess.CopyBoard(g)
+	if err := next.Move(res.Orig, res.Dest); err != nil {
+		return res, err
+	}
+	res.Score = next.Evaluate()
+	if turnOf(g) == "b" {
+		res.Score = -res.Score
+	}
+	return res, nil
+}
+
+func (MiniMaxEngine) Close() error { return nil }
+
+// NegamaxEngine is growser's search, on a bitboard
+// Position, deepening until Depth or MoveTime. It gives
+// real scores, tells Info and can be stopped, and
+// searches with Threads when searchPool has them idle.
+// Without a Table of its own it shares transTable, and
+// without Weights it evaluates with evalWeights.
+type NegamaxEngine struct {
+	Table   *TransTable
+	Weights *Weights
+}
+
+func (e NegamaxEngine) BestMove(g *ghess.Board, l Limits) (Result, error) {
+	if l.Human != nil {
+		return l.Human.play(g, e.Weights, l), nil
+	}
+	table := e.Table
+	if table == nil {
+		table = transTable
+	}
+	return lazySmp(g, table, e.Weights, l), nil
+}
+
+func (NegamaxEngine) Close() error { return nil }
+
+var (
+	// defaultEngine plays the levels without an engine of their own.
+	defaultEngine Engine = NegamaxEngine{}
+	// levelEngines are engines for particular difficulties.
+	levelEngines = make(map[int]Engine)
+	// analysisEngine scores the moves of finished games.
+	analysisEngine Engine = NegamaxEngine{}
+	// engineMoveTime is given to engines which search by time.
+	engineMoveTime = time.Second
+)
+
+// engineFor returns the Engine which plays difficulty diff.
+func engineFor(diff int) Engine {
+	if e, ok := levelEngines[diff]; ok {
+		return e
+	}
+	return defaultEngine
+}
+
+// limitsFor returns the search Limits of difficulty
+// diff, from its Profile if it has one.
+func limitsFor(diff int) Limits {
+	l := Limits{Depth: diff, MoveTime: engineMoveTime,
+		Threads: threadsFor(diff)}
+	if h, ok := levels[diff]; ok {
+		l.Depth = h.Depth
+		if h.weakened() {
+			l.Human = h
+		}
+	}
+	return l
+}
+
+// engineMove asks the Engine of diff for a move in g,
+// and falls back on the default Engine if that fails.
+// It isn't weakened, hints shouldn't blunder.
+func engineMove(g *ghess.Board, diff int) (Result, error) {
+	l := limitsFor(diff)
+	l.Human = nil
+	return cachedMove(g, diff, l)
+}
+
+// searchMove is engineMove with other Limits.
+func searchMove(g *ghess.Board, diff int, l Limits) (Result, error) {
+	e := engineFor(diff)
+	res, err := e.BestMove(g, l)
+	if err == nil && res.Orig == 0 {
+		err = errors.New("No move found")
+	}
+	if err != nil && e != defaultEngine {
+		fmt.Println("Engine failed, falling back:", err)
+		res, err = defaultEngine.BestMove(g, l)
+	}
+	return res, err
+}
+
+// thinkMove is how the AI chooses its moves: from the
+// books while the game, at ply, is young enough for
+// diff, and otherwise with the Engine of diff. It
+// returns true if the move is from a book.
+func thinkMove(g *ghess.Board, ply, diff int, l Limits) (Result, bool, error) {
+	if inBook(diff, ply) {
+		if orig, dest, ok := bookMove(g); ok {
+			return Result{Orig: orig, Dest: dest}, true, nil
+		}
+	}
+	res, err := cachedMove(g, diff, l)
+	return res, false, err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// tacticsSuite are the first positions of Win at Chess,
+// the classic tactical test, in EPD.
+var tacticsSuite = []string{
+	`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`,
+	`8/7p/5k2/5p2/p1p2P2/Pr1pPK2/1P1R3P/8 b - - bm Rxb2; id "WAC.002";`,
+	`5rk1/1ppb3p/p1pb4/6q1/3P1p1r/2P1R2P/PP1BQ1P1/5RKN w - - bm Rg3; id "WAC.003";`,
+	`r1bq2rk/pp3pbp/2p1p1pQ/7P/3P4/2PB1N2/PP3PPR/2KR4 w - - bm Qxh7+; id "WAC.004";`,
+	`5k2/6pp/p1qN4/1p1p4/3P4/2PKP2Q/PP3r2/3R4 b - - bm Qc4+; id "WAC.005";`,
+	`7k/p7/1R5K/6r1/6p1/6P1/8/8 w - - bm Rb7; id "WAC.006";`,
+	`rnbqkb1r/pppp1ppp/8/4P3/6n1/7P/PPPNPPP1/R1BQKBNR b KQkq - bm Ne3; id "WAC.007";`,
+	`r4q1k/p2bR1rp/2p2Q1N/5p2/5p2/2P5/PP3PPP/R5K1 w - - bm Rf7; id "WAC.008";`,
+	`3q1rk1/p4pp1/2pb3p/3p4/6Pr/1PNQ4/P1PB1PP1/4RRK1 b - - bm Bh2+; id "WAC.009";`,
+	`2br2k1/2q3rn/p2NppQ1/2p1P3/Pp5R/4P3/1P3PPP/3R2K1 w - - bm Rh7; id "WAC.010";`,
+	`r1b1kb1r/3q1ppp/pBp1pn2/8/Np3P2/5B2/PPP3PP/R2Q1RK1 w kq - bm Bxc6; id "WAC.011";`,
+	`4k1r1/2p3r1/1pR1p3/3pP2p/3P2qP/P4N2/1PQ4P/5R1K b - - bm Qxf3+; id "WAC.012";`,
+	`5rk1/pp4p1/2n1p2p/2Npq3/2p5/6P1/P3P1BP/R4Q1K w - - bm Qxf8+; id "WAC.013";`,
+	`r2rb1k1/pp1q1p1p/2n1p1p1/2bp4/5P2/PP1BPR1Q/1BPN2PP/R5K1 w - - bm Qxh7+; id "WAC.014";`,
+	`1R6/1brk2p1/4p2p/p1P1Pp2/P7/6P1/1P4P1/2R3K1 w - - bm Rxb7; id "WAC.015";`,
+	`r4rk1/ppp2ppp/2n5/2bqp3/8/P2PB3/1PP1NPPP/R2Q1RK1 w - - bm Nc3; id "WAC.016";`,
+	`1k5r/pppbn1pp/4q1r1/1P3p2/2NPp3/1QP5/P4PPP/R1B1R1K1 w - - bm Ne5; id "WAC.017";`,
+	`R7/P4k2/8/8/8/8/r7/6K1 w - - bm Rh8; id "WAC.018";`,
+	`r1b2rk1/ppbn1ppp/4p3/1QP4q/3P4/N4N2/5PPP/R1B2RK1 w - - bm c6; id "WAC.019";`,
+	`r2qkb1r/1ppb1ppp/p7/4p3/P1Q1P3/2P5/5PPP/R1B2KNR b kq - bm Bb5; id "WAC.020";`,
+}
+
+// epdCase is a test position with its best moves, or
+// the moves to avoid, in SAN.
+type epdCase struct {
+	Id    string
+	Fen   string
+	Best  []string
+	Avoid []string
+}
+
+// parseEpd reads an EPD line, only bm, am and id matter.
+func parseEpd(line string) (epdCase, error) {
+	c := epdCase{}
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return c, errInvalidFen
+	}
+	c.Fen = strings.Join(fields[:4], " ")
+	c.Id = c.Fen
+	ops := strings.Join(fields[4:], " ")
+	for _, op := range strings.Split(ops, ";") {
+		words := strings.Fields(op)
+		if len(words) < 2 {
+			continue
+		}
+		switch words[0] {
+		case "bm":
+			c.Best = words[1:]
+		case "am":
+			c.Avoid = words[1:]
+		case "id":
+			c.Id = strings.Trim(strings.Join(words[1:], " "), `"`)
+		}
+	}
+	if len(c.Best) == 0 && len(c.Avoid) == 0 {
+		return c, errors.New("No bm or am in " + line)
+	}
+	return c, nil
+}
+
+// sameSan compares moves in SAN, ignoring check marks,
+// annotations and capture marks, which suites leave out.
+func sameSan(a, b string) bool {
+	plain := func(mv string) string {
+		return strings.Replace(strings.TrimRight(mv, "+#!?"), "x", "", -1)
+	}
+	return plain(a) == plain(b)
+}
+
+// solve searches c for moveTime, or to depth, and
+// returns the move found and whether it's right.
+func solve(c epdCase, table *TransTable, simple bool, moveTime time.Duration, depth int) (string, bool) {
+	game, err := loadFen(c.Fen)
+	if err != nil {
+		return err.Error(), false
+	}
+	table.Clear()
+	s := &searcher{table: table, simple: simple}
+	if moveTime > 0 {
+		s.deadline = time.Now().Add(moveTime)
+	}
+	res := s.iterate(&game, depth, nil)
+	if res.Orig == 0 {
+		return "none", false
+	}
+	found := san(&game, res.Orig, res.Dest)
+	for _, mv := range c.Avoid {
+		if sameSan(found, mv) {
+			return found, false
+		}
+	}
+	if len(c.Best) == 0 {
+		return found, true
+	}
+	for _, mv := range c.Best {
+		if sameSan(found, mv) {
+			return found, true
+		}
+	}
+	return found, false
+}
+
+// EpdCommand runs "growser epd", counting the positions
+// of a tactical suite the search solves.
+func EpdCommand(args []string) {
+	flags := flag.NewFlagSet("epd", flag.ExitOnError)
+	file := flags.String("file", "", "EPD file, Win at Chess 1-20 without")
+	moveTime := flags.Duration("time", time.Second, "search time per position")
+	depth := flags.Int("depth", 0, "search depth per position, 0 for no limit")
+	compare := flags.Bool("compare", false, "also search without quiescence and ordering")
+	flags.Parse(args)
+
+	lines := tacticsSuite
+	if *file != "" {
+		var err error
+		lines, err = readOpenings(*file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	table := NewTransTable(defaultHashMB)
+	var solved, simpleSolved, total int
+	for _, line := range lines {
+		c, err := parseEpd(line)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		total++
+		found, ok := solve(c, table, false, *moveTime, *depth)
+		mark := "-"
+		if ok {
+			solved++
+			mark = "+"
+		}
+		out := fmt.Sprintf("%s %-10s bm %-8s found %-8s", mark, c.Id,
+			strings.Join(c.Best, " "), found)
+		if *compare {
+			found, ok = solve(c, table, true, *moveTime, *depth)
+			mark = "-"
+			if ok {
+				simpleSolved++
+				mark = "+"
+			}
+			out += fmt.Sprintf("  bare %s %s", mark, found)
+		}
+		fmt.Println(out)
+	}
+	fmt.Printf("Solved %d of %d", solved, total)
+	if *compare {
+		fmt.Printf(", %d without quiescence and ordering", simpleSolved)
+	}
+	fmt.Println()
+}
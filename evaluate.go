@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/bits"
+)
+
+// materialValues are the values of the kinds, as ghess
+// evaluates them. The kings cancel out.
+var materialValues = [5]int{100, 320, 330, 500, 900}
+
+// pieceSquare are the Simplified Evaluation Function
+// tables ghess follows, from white's side with a8
+// first. Black's squares are mirrored. Ghess leaves
+// out the rook, queen and king terms.
+var pieceSquare = [6][64]int{
+	{ // Pawn
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	},
+	{ // Knight
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	},
+	{ // Bishop
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	},
+	{ // Rook
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	},
+	{ // Queen
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	},
+	{ // King, for the middle game
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	},
+}
+
+// kingEndgame is the Simplified Evaluation Function
+// table for the king once the pieces are off.
+var kingEndgame = [64]int{
+	-50, -40, -30, -20, -20, -30, -40, -50,
+	-30, -20, -10, 0, 0, -10, -20, -30,
+	-30, -10, 20, 30, 30, 20, -10, -30,
+	-30, -10, 30, 40, 40, 30, -10, -30,
+	-30, -10, 30, 40, 40, 30, -10, -30,
+	-30, -10, 20, 30, 30, 20, -10, -30,
+	-30, -30, 0, 0, 0, 0, -30, -30,
+	-50, -30, -30, -30, -30, -30, -30, -50,
+}
+
+// Weights parameterize the evaluation, in centipawns.
+// The tables are from white's side with a8 first.
+type Weights struct {
+	Material         [5]int     `json:"material"` // pawn to queen
+	PieceSquare      [6][64]int `json:"pieceSquare"`
+	KingEndgame      [64]int    `json:"kingEndgame"`
+	BishopPair       int        `json:"bishopPair"`
+	RookOpenFile     int        `json:"rookOpenFile"`
+	RookHalfOpenFile int        `json:"rookHalfOpenFile"`
+	DoubledPawn      int        `json:"doubledPawn"`
+	IsolatedPawn     int        `json:"isolatedPawn"`
+	PassedPawn       [8]int     `json:"passedPawn"` // by rank
+}
+
+// Offsets of the weights in a trace, in the order of params.
+const (
+	tMaterial         = 0
+	tPieceSquare      = tMaterial + 5
+	tKingEndgame      = tPieceSquare + 6*64
+	tBishopPair       = tKingEndgame + 64
+	tRookOpenFile     = tBishopPair + 1
+	tRookHalfOpenFile = tRookOpenFile + 1
+	tDoubledPawn      = tRookHalfOpenFile + 1
+	tIsolatedPawn     = tDoubledPawn + 1
+	tPassedPawn       = tIsolatedPawn + 1
+	numWeights        = tPassedPawn + 8
+)
+
+// maxPhase is the phase of all the pieces on the board,
+// a knight or bishop counting 1, a rook 2 and a queen 4.
+const maxPhase = 24
+
+var (
+	fileMasks     [8]uint64
+	adjacentFiles [8]uint64
+	passedMasks   [2][64]uint64 // squares of pawns which stop one
+)
+
+func init() {
+	for f := 0; f < 8; f++ {
+		for r := 0; r < 8; r++ {
+			fileMasks[f] |= 1 << uint(r*8+f)
+		}
+	}
+	for f := 0; f < 8; f++ {
+		if f > 0 {
+			adjacentFiles[f] |= fileMasks[f-1]
+		}
+		if f < 7 {
+			adjacentFiles[f] |= fileMasks[f+1]
+		}
+	}
+	for sq := 0; sq < 64; sq++ {
+		files := fileMasks[sq%8] | adjacentFiles[sq%8]
+		for r := 0; r < 8; r++ {
+			rank := uint64(0xff) << uint(r*8)
+			if r > sq/8 {
+				passedMasks[White][sq] |= files & rank
+			}
+			if r < sq/8 {
+				passedMasks[Black][sq] |= files & rank
+			}
+		}
+	}
+}
+
+// defaultWeights are ghess's evaluation, completed.
+func defaultWeights() *Weights {
+	return &Weights{
+		Material:         materialValues,
+		PieceSquare:      pieceSquare,
+		KingEndgame:      kingEndgame,
+		BishopPair:       30,
+		RookOpenFile:     20,
+		RookHalfOpenFile: 10,
+		DoubledPawn:      -15,
+		IsolatedPawn:     -10,
+		PassedPawn:       [8]int{0, 5, 10, 20, 35, 60, 100, 0},
+	}
+}
+
+// evalWeights are what searches evaluate with unless
+// told otherwise.
+var evalWeights = defaultWeights()
+
+// LoadWeights reads weights written by growser tune,
+// what the file leaves out keeps its default.
+func LoadWeights(path string) (*Weights, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w := defaultWeights()
+	err = json.Unmarshal(data, w)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// SaveWeights writes w as json.
+func SaveWeights(path string, w *Weights) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// params returns the weights in the order of a trace.
+func (w *Weights) params() []*int {
+	ps := make([]*int, 0, numWeights)
+	for i := range w.Material {
+		ps = append(ps, &w.Material[i])
+	}
+	for kind := range w.PieceSquare {
+		for i := range w.PieceSquare[kind] {
+			ps = append(ps, &w.PieceSquare[kind][i])
+		}
+	}
+	for i := range w.KingEndgame {
+		ps = append(ps, &w.KingEndgame[i])
+	}
+	ps = append(ps, &w.BishopPair, &w.RookOpenFile, &w.RookHalfOpenFile,
+		&w.DoubledPawn, &w.IsolatedPawn)
+	for i := range w.PassedPawn {
+		ps = append(ps, &w.PassedPawn[i])
+	}
+	return ps
+}
+
+// evaluate scores the position in centipawns for
+// white with w.
+func (p *Position) evaluate(w *Weights) int {
+	return p.score(w, nil)
+}
+
+// score is evaluate, and if trace isn't nil it adds
+// up how much each weight counts for white, in the
+// order of params, so the evaluation is the trace
+// times the weights.
+func (p *Position) score(w *Weights, trace []float64) int {
+	phase := 0
+	for colour := White; colour <= Black; colour++ {
+		phase += bits.OnesCount64(p.pieces[colour][Knight]|p.pieces[colour][Bishop]) +
+			2*bits.OnesCount64(p.pieces[colour][Rook]) +
+			4*bits.OnesCount64(p.pieces[colour][Queen])
+	}
+	if phase > maxPhase {
+		phase = maxPhase
+	}
+	score := 0
+	king := 0 // times maxPhase, between the tables
+	for colour := White; colour <= Black; colour++ {
+		sign := 1 - 2*colour
+		pawns, theirPawns := p.pieces[colour][Pawn], p.pieces[1-colour][Pawn]
+		for kind := Pawn; kind <= Queen; kind++ {
+			for b := p.pieces[colour][kind]; b != 0; b &= b - 1 {
+				sq := bits.TrailingZeros64(b)
+				i := tableSquare(colour, sq)
+				score += sign * (w.Material[kind] + w.PieceSquare[kind][i])
+				if trace != nil {
+					trace[tMaterial+kind] += float64(sign)
+					trace[tPieceSquare+kind*64+i] += float64(sign)
+				}
+			}
+		}
+		i := tableSquare(colour, p.kingSquare(colour))
+		king += sign * (w.PieceSquare[King][i]*phase + w.KingEndgame[i]*(maxPhase-phase))
+		if trace != nil {
+			trace[tPieceSquare+King*64+i] += float64(sign*phase) / maxPhase
+			trace[tKingEndgame+i] += float64(sign*(maxPhase-phase)) / maxPhase
+		}
+		if bits.OnesCount64(p.pieces[colour][Bishop]) >= 2 {
+			score += sign * w.BishopPair
+			if trace != nil {
+				trace[tBishopPair] += float64(sign)
+			}
+		}
+		for b := p.pieces[colour][Rook]; b != 0; b &= b - 1 {
+			file := fileMasks[bits.TrailingZeros64(b)%8]
+			switch {
+			case file&(pawns|theirPawns) == 0:
+				score += sign * w.RookOpenFile
+				if trace != nil {
+					trace[tRookOpenFile] += float64(sign)
+				}
+			case file&pawns == 0:
+				score += sign * w.RookHalfOpenFile
+				if trace != nil {
+					trace[tRookHalfOpenFile] += float64(sign)
+				}
+			}
+		}
+		for f := 0; f < 8; f++ {
+			n := bits.OnesCount64(pawns & fileMasks[f])
+			if n == 0 {
+				continue
+			}
+			if n > 1 {
+				score += sign * (n - 1) * w.DoubledPawn
+				if trace != nil {
+					trace[tDoubledPawn] += float64(sign * (n - 1))
+				}
+			}
+			if pawns&adjacentFiles[f] == 0 {
+				score += sign * n * w.IsolatedPawn
+				if trace != nil {
+					trace[tIsolatedPawn] += float64(sign * n)
+				}
+			}
+		}
+		for b := pawns; b != 0; b &= b - 1 {
+			sq := bits.TrailingZeros64(b)
+			if passedMasks[colour][sq]&theirPawns != 0 {
+				continue
+			}
+			rank := sq / 8
+			if colour == Black {
+				rank = 7 - rank
+			}
+			score += sign * w.PassedPawn[rank]
+			if trace != nil {
+				trace[tPassedPawn+rank] += float64(sign)
+			}
+		}
+	}
+	return score + king/maxPhase
+}
+
+// tableSquare returns the index of sq in a table for
+// colour, which has a8 first from white's side.
+func tableSquare(colour, sq int) int {
+	if colour == White {
+		return (7-sq/8)*8 + sq%8
+	}
+	return sq
+}
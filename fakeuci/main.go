@@ -0,0 +1,112 @@
+// Command fakeuci is a tiny UCI engine for trying
+// growser's UCI adapter without a real engine:
+//
+//	go build ./fakeuci
+//	growser -uci ./fakeuci/fakeuci -ucilevels 3,4,5
+//
+// It plays the first valid move ghess finds. With -delay
+// it thinks slowly, and with -hang it never answers go,
+// which exercises the adapter's timeouts.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+func main() {
+	delay := flag.Duration("delay", 0, "how long to think per move")
+	hang := flag.Bool("hang", false, "never answer go")
+	flag.Parse()
+
+	game := ghess.NewBoard()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 1 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name fakeuci")
+			fmt.Println("id author growser")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "ucinewgame":
+			game = ghess.NewBoard()
+		case "position":
+			game = position(fields[1:])
+		case "go":
+			if *hang {
+				continue
+			}
+			time.Sleep(*delay)
+			origs, dests := game.SearchValid()
+			if len(origs) < 1 {
+				fmt.Println("info depth 0 score mate 0")
+				fmt.Println("bestmove (none)")
+				continue
+			}
+			fmt.Println("info depth 1 score cp 0 pv " + ghess.PieceMap[origs[0]] + ghess.PieceMap[dests[0]])
+			fmt.Println("bestmove " + uciMove(origs[0], dests[0]))
+		case "quit":
+			return
+		}
+	}
+}
+
+// position reads "startpos" or "fen <fen>", then "moves ...".
+func position(args []string) ghess.Board {
+	game := ghess.NewBoard()
+	i := 0
+	if len(args) > 0 && args[0] == "startpos" {
+		i = 1
+	} else if len(args) > 6 && args[0] == "fen" {
+		fen := args[1:7]
+		// ghess reads a single digit half move clock
+		fen[4] = "0"
+		game.LoadFen(strings.Join(fen, " "))
+		i = 7
+	}
+	if i < len(args) && args[i] == "moves" {
+		for _, mv := range args[i+1:] {
+			if len(mv) < 4 {
+				break
+			}
+			orig := ghess.PgnToCoordMap[mv[0:2]]
+			dest := ghess.PgnToCoordMap[mv[2:4]]
+			// Castles go onto the rook in ghess, the
+			// fake assumes only kings move like that.
+			switch {
+			case mv[0:4] == "e1g1" && orig == 14:
+				dest = 11
+			case mv[0:4] == "e1c1" && orig == 14:
+				dest = 18
+			case mv[0:4] == "e8g8" && orig == 84:
+				dest = 81
+			case mv[0:4] == "e8c8" && orig == 84:
+				dest = 88
+			}
+			game.Move(orig, dest)
+		}
+	}
+	return game
+}
+
+// uciMove writes a ghess move in UCI notation.
+func uciMove(orig, dest int) string {
+	switch {
+	case orig == 14 && dest == 11, orig == 84 && dest == 81:
+		dest = orig - 2
+	case orig == 14 && dest == 18, orig == 84 && dest == 88:
+		dest = orig + 2
+	}
+	return ghess.PieceMap[orig] + ghess.PieceMap[dest]
+}
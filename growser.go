@@ -4,8 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"math/rand"
 	"net/http"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // TODO:
@@ -21,10 +26,91 @@ var db *bolt.DB
 
 var hub *Hub
 
+var standingsHub *StandingsHub
+
+// commands are the subcommands of growser, eg
+// "growser uci". Without one growser serves chess.
+var commands = map[string]func(args []string){
+	"uci":       UciCommand,
+	"bench":     BenchCommand,
+	"calibrate": CalibrateCommand,
+	"epd":       EpdCommand,
+	"match":     MatchCommand,
+	"perft":     PerftCommand,
+	"tune":      TuneCommand,
+	"xboard":    XboardCommand,
+}
+
 // Open Bolddb connection
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
 	portFlag := flag.String("port", "8080", "the server port, prefixed by :")
+	bookFlag := flag.String("book", "", "Polyglot opening books, comma separated")
+	depthFlag := flag.String("bookdepth", "", "plies played from the books per difficulty, eg 3=8,4=12,5=20")
+	uciFlag := flag.String("uci", "", "a UCI engine binary to play some levels with")
+	uciLevels := flag.String("ucilevels", "5", "difficulties the UCI engine plays, comma separated")
+	uciPool := flag.Int("ucipool", 2, "most UCI engine processes at once")
+	uciTime := flag.Duration("ucimovetime", time.Second, "time the UCI engine gets per move")
+	uciAnalysis := flag.Bool("ucianalysis", false, "analyse finished games with the UCI engine")
+	weightsFlag := flag.String("weights", "", "evaluation weights written by growser tune")
+	cacheFlag := flag.Int("cachesize", defaultCacheSize, "moves the engine cache keeps, 0 for none")
+	hashFlag := flag.Int("hash", defaultHashMB, "transposition table size in MB, shared by all games")
+	threadsFlag := flag.Int("threads", runtime.NumCPU(), "most helper threads searching at once, over all games")
+	ponderFlag := flag.Bool("ponder", false, "think on the human's time")
+	ponderTimeFlag := flag.Duration("pondertime", ponderTime, "longest the AI thinks on the human's time")
+	searchThreads := flag.String("searchthreads", "", "threads a move searches with per difficulty, eg 4=2,5=8")
+	flag.Parse()
+	rand.Seed(time.Now().UTC().UnixNano())
+	// Opening books
+	err := LoadBooks(*bookFlag)
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = ParseBookDepth(*depthFlag)
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Engines
+	if *weightsFlag != "" {
+		w, err := LoadWeights(*weightsFlag)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			evalWeights = w
+		}
+	}
+	if *hashFlag != defaultHashMB {
+		transTable = NewTransTable(*hashFlag)
+	}
+	searchPool = newThreadPool(*threadsFlag)
+	pondering = *ponderFlag
+	ponderTime = *ponderTimeFlag
+	err = ParseSearchThreads(*searchThreads)
+	if err != nil {
+		fmt.Println(err)
+	}
+	engineMoveTime = *uciTime
+	if *uciFlag != "" {
+		uci := NewUCIEngine(*uciFlag, *uciPool)
+		defer uci.Close()
+		for _, level := range strings.Split(*uciLevels, ",") {
+			diff, err := strconv.Atoi(level)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			levelEngines[diff] = uci
+		}
+		if *uciAnalysis {
+			analysisEngine = uci
+		}
+	}
 	// Handle DB connection
 	blt, err := bolt.Open("games.db", 0644, nil)
 	if err != nil {
@@ -57,9 +143,91 @@ func main() {
 		fmt.Println(err)
 	}
 
+	// bucket for game records
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(records)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// indexes of the games of every player
+	err = reindexRecords()
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = rebuildLeaderboards()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// bucket for finished game analysis
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(analyses)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// bucket for players of the AI
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(players)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// buckets for accounts and their logins
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(users)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(sessions)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = expireSessions()
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = loadCookieKey()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// bucket for cached engine moves
+	if *cacheFlag > 0 {
+		engineCache, err = NewEngineCache(*cacheFlag)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			go engineCache.flushEvery(cacheFlushTime)
+		}
+	}
+
 	// Launch websocket hub
 	hub = newHub()
 	go hub.run()
+	standingsHub = newStandingsHub()
+	go standingsHub.run()
 
 	// connection
 	router := NewRouter()
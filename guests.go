@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// secrets holds the server's keys, made on first start.
+var secrets = []byte("secrets")
+
+// guestCookie names the cookie a guest is known by, an
+// id then its signature.
+const guestCookie = "growser_guest"
+
+// guestPrefix starts the seats of guests in a Record,
+// names may not have a colon so they can't clash.
+const guestPrefix = "guest:"
+
+// guestAge is how long a guest cookie lasts, in seconds.
+const guestAge = 365 * 24 * 60 * 60
+
+// cookieKey signs the guest cookies.
+var cookieKey []byte
+
+// loadCookieKey reads the key guest cookies are signed
+// with, making one the first time.
+func loadCookieKey() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(secrets)
+		if err != nil {
+			return err
+		}
+		if key := bucket.Get([]byte("cookie")); key != nil {
+			cookieKey = append([]byte(nil), key...)
+			return nil
+		}
+		cookieKey = make([]byte, 32)
+		_, err = rand.Read(cookieKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("cookie"), cookieKey)
+	})
+}
+
+// signGuest returns the cookie value of a guest id.
+func signGuest(id string) string {
+	mac := hmac.New(sha256.New, cookieKey)
+	mac.Write([]byte(id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyGuest returns the guest id of a cookie value,
+// if it was signed by this server.
+func verifyGuest(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 1 || len(cookieKey) == 0 {
+		return "", false
+	}
+	id := value[:i]
+	return id, hmac.Equal([]byte(signGuest(id)), []byte(value))
+}
+
+// guestId returns the guest id of r, giving a new
+// visitor one.
+func guestId(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(guestCookie); err == nil {
+		if id, ok := verifyGuest(c.Value); ok {
+			return id
+		}
+	}
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		fmt.Println(err)
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCookie,
+		Value:    signGuest(id),
+		Path:     "/",
+		MaxAge:   guestAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// claimGuest gives the games and results of a guest
+// to the account of name, when they register.
+func claimGuest(guest, name string) error {
+	seat := guestPrefix + guest
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, id := range seatGameIds(tx, seat) {
+			rec := getRecord(tx, id)
+			if rec.White == seat {
+				rec.White = name
+			}
+			if rec.Black == seat {
+				rec.Black = name
+			}
+			err := putRecord(tx, rec)
+			if err != nil {
+				return err
+			}
+		}
+		return movePlayer(tx, seat, name)
+	})
+}
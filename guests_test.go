@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestGuestCookie(t *testing.T) {
+	openTestDb(t)
+	defer func(saved []byte) { cookieKey = saved }(cookieKey)
+	if err := loadCookieKey(); err != nil {
+		t.Fatal(err)
+	}
+	value := signGuest("abc")
+	if id, ok := verifyGuest(value); !ok || id != "abc" {
+		t.Errorf("verified %q %v", id, ok)
+	}
+	for _, bad := range []string{"abc", "abd" + value[3:], value + "0", "." + value[4:]} {
+		if _, ok := verifyGuest(bad); ok {
+			t.Errorf("%q verified", bad)
+		}
+	}
+
+	// A new visitor gets an id, which their cookie keeps
+	w := httptest.NewRecorder()
+	id := guestId(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if id == "" || len(cookies) != 1 || cookies[0].Name != guestCookie {
+		t.Fatalf("guest %q with %v", id, cookies)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	if again := guestId(httptest.NewRecorder(), r); again != id {
+		t.Errorf("guest %q came back as %q", id, again)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: guestCookie, Value: "forged.00"})
+	if other := guestId(httptest.NewRecorder(), r); other == "forged" {
+		t.Error("forged cookie taken")
+	}
+}
+
+// Registering takes the guest's games and level.
+func TestClaimGuest(t *testing.T) {
+	openTestDb(t)
+	seat := guestPrefix + "abc"
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range []Record{
+			{Id: "1", Kind: "ai", White: seat, Black: aiSeat},
+			{Id: "2", Kind: "challenge", White: "bob", Black: seat},
+			{Id: "3", Kind: "challenge", White: "bob", Black: guestPrefix + "other"},
+		} {
+			if err := putRecord(tx, rec); err != nil {
+				return err
+			}
+		}
+		return putPlayer(tx, Player{Id: seat, Level: 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := claimGuest("abc", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if rec := getRecord(tx, "1"); rec.White != "alice" {
+			t.Errorf("game 1 white %q", rec.White)
+		}
+		if rec := getRecord(tx, "2"); rec.Black != "alice" {
+			t.Errorf("game 2 black %q", rec.Black)
+		}
+		if rec := getRecord(tx, "3"); rec.Black != guestPrefix+"other" {
+			t.Errorf("game 3 black %q", rec.Black)
+		}
+		if p := getPlayer(tx, "alice"); p.Level != 2 {
+			t.Errorf("alice at level %d, want 2", p.Level)
+		}
+		return nil
+	})
+}
@@ -16,14 +16,18 @@ import (
 )
 
 type GameList struct {
-	Ai map[string]string
-	Vs map[string]string
+	Ai   map[string]string
+	Vs   map[string]string
+	User *User // logged in, or nil
+	// Categories a rated game may be played in
+	Categories []string
 }
 
 // Index page, link to new game
 func Index(w http.ResponseWriter,
 	r *http.Request) {
-	gameList := GameList{Ai: make(map[string]string), Vs: make(map[string]string)}
+	gameList := GameList{Ai: make(map[string]string), Vs: make(map[string]string),
+		User: userFrom(r), Categories: categories}
 
 	db.View(func(tx *bolt.Tx) error {
 		// Assume bucket exists and has keys
@@ -68,28 +72,41 @@ func NewGame(w http.ResponseWriter,
 	vars := mux.Vars(r)
 	color := vars["player"]
 	game := ghess.NewBoard()
+	rec := Record{Kind: "ai", Start: game.Position(),
+		White: seatOf(r), Black: aiSeat}
+	rec.rateFrom(r)
 	if color == "black" {
-		game.Move(24, 44)
+		rec.White, rec.Black = aiSeat, seatOf(r)
+		// Open from the book, or else with e4
+		orig, dest, ok := bookMove(&game)
+		if !ok {
+			orig, dest = 24, 44
+		}
+		game.Move(orig, dest)
+		rec.Moves = append(rec.Moves, moveString(orig, dest))
 	}
 	// new Board
 	// Make first move if black
 
 	// Key Value Pair
 	value := []byte(game.Position())
-	//key := []byte(time.Now().Format("15:04:05"))
-	s := strconv.FormatInt(time.Now().Unix(), 10)
-	key := []byte(s)
+	var key []byte
 	// Add to Database
 	err := db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte("games"))
 		if err != nil {
 			return err
 		}
+		key, err = newGameKey(tx, bucket)
+		if err != nil {
+			return err
+		}
 		err = bucket.Put(key, value)
 		if err != nil {
 			return err
 		}
-		return nil
+		rec.Id = string(key)
+		return putRecord(tx, rec)
 	})
 	if err != nil {
 		fmt.Println(err)
@@ -98,10 +115,38 @@ func NewGame(w http.ResponseWriter,
 	http.Redirect(w, r, "/view/"+string(key), http.StatusSeeOther)
 }
 
+// newGameKey returns the next key of bucket, games or
+// challenges, by its sequence. Keys were the time, which
+// games made in the same second shared, and games and
+// challenges share the records bucket, so any key in
+// use in one of them is skipped.
+func newGameKey(tx *bolt.Tx, bucket *bolt.Bucket) ([]byte, error) {
+	for {
+		n, err := bucket.NextSequence()
+		if err != nil {
+			return nil, err
+		}
+		key := []byte(strconv.FormatUint(n, 10))
+		used := false
+		for _, name := range [][]byte{games, []byte("challenges"), records} {
+			if b := tx.Bucket(name); b != nil && b.Get(key) != nil {
+				used = true
+			}
+		}
+		if !used {
+			return key, nil
+		}
+	}
+}
+
 type Game struct {
 	Position   string
 	Id         string
-	Difficulty int
+	Difficulty int    // 0 for Auto
+	Level      string // the level Auto plays
+	Watching   bool   // someone else's game, read only
+	Rated      bool
+	Category   string
 }
 
 func ViewGame(w http.ResponseWriter,
@@ -109,6 +154,7 @@ func ViewGame(w http.ResponseWriter,
 	vars := mux.Vars(r)
 	id := vars["id"]
 	var pos string
+	var rec Record
 	// Read
 	err := db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(games)
@@ -117,6 +163,7 @@ func ViewGame(w http.ResponseWriter,
 		}
 		val := bucket.Get([]byte(id))
 		pos = string(val)
+		rec = getRecord(tx, id)
 		return nil
 	})
 	if err != nil {
@@ -127,7 +174,12 @@ func ViewGame(w http.ResponseWriter,
 	if err != nil {
 		fmt.Printf("Error %s Templates", err)
 	}
-	g := Game{Position: pos, Id: id, Difficulty: 4}
+	level := playerLevel(seatOf(r))
+	if rec.Rated && rec.Level > 0 {
+		level = rec.Level // it can't change
+	}
+	g := Game{Position: pos, Id: id, Level: levelName(level),
+		Watching: !ownsGame(r, rec), Rated: rec.Rated, Category: rec.Category}
 	t.Execute(w, g)
 }
 
@@ -141,6 +193,10 @@ type Move struct {
 	Check     bool   `json:"check"`
 	Checkmate bool   `json:"checkmate"`
 	Error     bool   `json:"error"`
+	Coach     string `json:"coach"`
+	Result    string `json:"result"`
+	Level     int    `json:"level"`               // the level played
+	NextLevel string `json:"nextLevel,omitempty"` // Auto's, once it's over
 }
 
 // AJAX call to make move
@@ -152,7 +208,9 @@ func PlayGame(w http.ResponseWriter,
 	orig := vars["orig"]
 	dest := vars["dest"]
 	diff, _ := strconv.Atoi(vars["diff"])
+	player := seatOf(r)
 	var pos string
+	var rec Record
 	// Get game from DB
 	err := db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(games)
@@ -161,18 +219,47 @@ func PlayGame(w http.ResponseWriter,
 		}
 		val := bucket.Get([]byte(id))
 		pos = string(val)
+		rec = getRecord(tx, id)
 		return nil
 	})
 	if err != nil {
 		fmt.Println(err)
 	}
+	if !ownsGame(r, rec) {
+		forbidGame(w, id, pos)
+		return
+	}
+	// Auto plays the player's level, and a rated game
+	// the level of its first move
+	if rec.Rated && rec.Level > 0 {
+		diff = rec.Level
+	}
+	if diff < 1 {
+		diff = playerLevel(player)
+	}
+	rec.Level = diff
 	// Set up board
 	game := ghess.NewBoard()
 	err = game.LoadFen(pos)
 	if err != nil {
 		fmt.Println(err)
 	}
+	// The coach judges the move from the position before it
+	var before *ghess.Board
+	if rec.Coach {
+		before = ghess.CopyBoard(&game)
+	}
 	// Make move and ask AI
+	colour := turnOf(&game)
+	wasOver := rec.Result != ""
+	// A game from before seats is the first mover's
+	if rec.predatesSeats() {
+		rec.Kind = "ai"
+		rec.White, rec.Black = seatOf(r), aiSeat
+		if colour == "b" {
+			rec.White, rec.Black = aiSeat, seatOf(r)
+		}
+	}
 	mv := &Move{}
 	err = game.ParseStand(orig, dest)
 	if err != nil {
@@ -183,6 +270,19 @@ func PlayGame(w http.ResponseWriter,
 			Error:    true,
 		}
 	} else {
+		rec.Moves = append(rec.Moves, moveString(
+			ghess.PgnToCoordMap[orig], ghess.PgnToCoordMap[dest]))
+		// What the human's move lost, before the AI answers
+		var why string
+		var loss int
+		if before != nil {
+			o, d := ghess.PgnToCoordMap[orig], ghess.PgnToCoordMap[dest]
+			why, _ = explainMove(before, o, d)
+			loss, err = moveLoss(before, o, d)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
 		if game.Checkmate {
 			msg := "> I've been Checkmated! Good game"
 			mv = &Move{
@@ -193,13 +293,27 @@ func PlayGame(w http.ResponseWriter,
 			}
 		} else {
 			now := time.Now()
-			state, err := ghess.MiniMaxPruning(0, diff, ghess.GetState(&game))
-			if err != nil {
-				fmt.Println("Minimax broken")
+			// The move may have been thought of on the human's time
+			res, pondered := takePonder(id, &game)
+			var book bool
+			if !pondered {
+				res, book, err = thinkMove(&game, len(rec.Moves),
+					diff, limitsFor(diff))
+				if err != nil {
+					fmt.Println("Engine broken", err)
+				}
 			}
-			game.Move(state.Init[0], state.Init[1])
+			orig, dest := res.Orig, res.Dest
+			game.Move(orig, dest)
+			if gameResult(&game) == "" {
+				startPonder(id, &game, res.Pv, diff)
+			}
+			rec.Moves = append(rec.Moves, moveString(orig, dest))
 			msg := fmt.Sprintf("> Your Turn, <br><br><i>my move took %s</i>",
 				time.Since(now))
+			if book {
+				msg = "> Your Turn, <br><br><i>that one's from my book</i>"
+			}
 			if game.Checkmate {
 				msg = "> Game Over, Checkmate >:D"
 
@@ -209,12 +323,26 @@ func PlayGame(w http.ResponseWriter,
 			mv = &Move{
 				Position: game.Position(),
 				Message:  msg,
-				LastMove: ghess.PieceMap[state.Init[1]],
-				LastOrig: ghess.PieceMap[state.Init[0]],
+				LastMove: ghess.PieceMap[dest],
+				LastOrig: ghess.PieceMap[orig],
 				GameId:   id,
 				Check:    game.Check,
 			}
-
+			// Warn if the human's move lost too much
+			if rec.Coach && loss > coachThreshold {
+				mv.Coach = fmt.Sprintf("> Coach: that move lost about %.1f pawns,<br><br><i>%s</i>",
+					float64(loss)/100, why)
+			}
+		}
+		rec.Result = gameResult(&game)
+		mv.Result = rec.Result
+		mv.Level = diff
+		if rec.Result != "" && !wasOver {
+			mv.NextLevel = levelName(finishGame(player, diff, colour, rec.Result))
+			err = rateGame(rec)
+			if err != nil {
+				fmt.Println(err)
+			}
 		}
 		err = db.Update(func(tx *bolt.Tx) error {
 			bucket := tx.Bucket(games)
@@ -222,8 +350,15 @@ func PlayGame(w http.ResponseWriter,
 			if err != nil {
 				return err
 			}
-			return nil
+			return putRecord(tx, rec)
 		})
+		if err != nil {
+			fmt.Println(err)
+		}
+		if rec.Result != "" {
+			stopPonder(id)
+			startAnalysis(id)
+		}
 	}
 	js, err := json.Marshal(mv)
 	if err != nil {
@@ -233,6 +368,78 @@ func PlayGame(w http.ResponseWriter,
 
 }
 
+// Targets is the list of legal destinations
+// for the piece on Origin, so the browser can
+// highlight them and refuse illegal drops.
+type Targets struct {
+	Origin  string   `json:"origin"`
+	Targets []string `json:"targets"`
+	GameId  string   `json:"id"`
+}
+
+// AJAX call for the legal moves of a square
+func LegalMoves(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	orig := vars["orig"]
+	var pos string
+	found := false
+	// Get game from DB
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(games)
+		if bucket == nil {
+			return nil
+		}
+		val := bucket.Get([]byte(id))
+		found = val != nil
+		pos = string(val)
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	// Set up board
+	game := ghess.NewBoard()
+	err = game.LoadFen(pos)
+	if err != nil {
+		fmt.Println(err)
+	}
+	t := &Targets{
+		Origin:  orig,
+		Targets: legalTargets(&game, orig),
+		GameId:  id,
+	}
+	js, err := json.Marshal(t)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write([]byte(js))
+}
+
+// legalTargets returns the squares, in standard
+// notation, which the piece on orig can move to.
+// Castling targets are the rook squares, the same
+// as the board expects for a castle drop.
+func legalTargets(g *ghess.Board, orig string) []string {
+	targets := make([]string, 0)
+	o, ok := ghess.PgnToCoordMap[orig]
+	if !ok {
+		return targets
+	}
+	origs, dests := g.SearchValid()
+	for i := 0; i < len(origs); i++ {
+		if origs[i] == o {
+			targets = append(targets, ghess.PieceMap[dests[i]])
+		}
+	}
+	return targets
+}
+
 /* Websockets! */
 
 func NewChallenge(w http.ResponseWriter,
@@ -241,18 +448,26 @@ func NewChallenge(w http.ResponseWriter,
 
 	// Key Value Pair
 	value := []byte(game.Position())
-	key := []byte(time.Now().Format("15:04:05"))
+	var key []byte
 	// Add to Database
 	err := db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte("challenges"))
 		if err != nil {
 			return err
 		}
+		key, err = newGameKey(tx, bucket)
+		if err != nil {
+			return err
+		}
 		err = bucket.Put(key, value)
 		if err != nil {
 			return err
 		}
-		return nil
+		// Whoever made the challenge plays white
+		rec := Record{Id: string(key), Kind: "challenge",
+			Start: game.Position(), White: seatOf(r)}
+		rec.rateFrom(r)
+		return putRecord(tx, rec)
 	})
 	if err != nil {
 		fmt.Println(err)
@@ -304,7 +519,8 @@ func serveWs(id string, w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256),
+		user: seatOf(r), game: id}
 	client.hub.register <- client
 
 	var pos string
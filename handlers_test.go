@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/polypmer/ghess"
+)
+
+func TestLegalTargets(t *testing.T) {
+	game := ghess.NewBoard()
+	cases := []struct {
+		orig string
+		want []string
+	}{
+		{"e2", []string{"e3", "e4"}},
+		{"g1", []string{"f3", "h3"}},
+		{"e1", []string{}},
+		{"e7", []string{}}, // not white's
+		{"e4", []string{}}, // empty
+		{"z9", []string{}},
+	}
+	for _, c := range cases {
+		got := legalTargets(&game, c.orig)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.orig, got, c.want)
+		}
+	}
+}
+
+func TestLegalMoves(t *testing.T) {
+	openTestDb(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(games)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("1"), []byte(startFen))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/moves/{id}/{orig}", LegalMoves)
+	cases := []struct {
+		path string
+		code int
+		want []string
+	}{
+		{"/moves/1/b1", http.StatusOK, []string{"a3", "c3"}},
+		{"/moves/2/b1", http.StatusNotFound, nil},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: %d, want %d", c.path, w.Code, c.code)
+			continue
+		}
+		if c.code != http.StatusOK {
+			continue
+		}
+		var got Targets
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got.Targets)
+		if !reflect.DeepEqual(got.Targets, c.want) {
+			t.Errorf("%s: %v, want %v", c.path, got.Targets, c.want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// leaderboards orders the users of every board: the
+	// board, a zero byte, the score inverted so the best
+	// come first, then the lower cased name, to a Leader.
+	leaderboards = []byte("leaderboards")
+	// leaderKeys holds the leaderboards key of each user
+	// on a board, by the board, a zero byte and the name.
+	leaderKeys = []byte("leaderkeys")
+	// leadersVersionKey is where leaderKeys keeps the
+	// leadersVersion its boards were built with, a key
+	// no board and name make.
+	leadersVersionKey = []byte("\x00version")
+)
+
+// leadersVersion is the version of how the boards are
+// built, see rebuildLeaderboards. Changing it rebuilds
+// them.
+const leadersVersion = "1"
+
+// Leaderboard filters, unless asked otherwise
+const (
+	defaultMinGames = 5
+	// defaultMaxDeviation leaves out ratings which grew
+	// too unsure while their player didn't play, or
+	// which have too few games behind them.
+	defaultMaxDeviation = 150
+	// defaultActiveDays leaves out players who haven't
+	// played a level for longer.
+	defaultActiveDays = 90
+	// boardTop is how many a board shows, boardPreview
+	// how many of each board the overview shows.
+	boardTop     = 100
+	boardPreview = 5
+)
+
+// Leader is a user's entry on a board. Rating boards
+// order by rating, level boards by wins.
+type Leader struct {
+	Rank       int       `json:"rank"`
+	Name       string    `json:"name"`
+	Rating     float64   `json:"rating,omitempty"`
+	Deviation  float64   `json:"deviation,omitempty"`
+	Volatility float64   `json:"volatility,omitempty"`
+	Wins       int       `json:"wins"`
+	Draws      int       `json:"draws"`
+	Losses     int       `json:"losses"`
+	Games      int       `json:"games"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
+// Board is a leaderboard as it's shown.
+type Board struct {
+	Name    string   `json:"name"`  // its url, a category or a level
+	Title   string   `json:"title"` // what it's called
+	Filters string   `json:"filters"`
+	Leaders []Leader `json:"leaders"`
+}
+
+// levelBoard returns the board of wins against level.
+func levelBoard(level int) string {
+	return strings.ToLower(levelName(level))
+}
+
+// boardLevel returns the level of a level board.
+func boardLevel(board string) (int, bool) {
+	for level := range levels {
+		if levelBoard(level) == board {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// boards returns every board, categories then levels
+// from the hardest.
+func boards() []string {
+	all := append([]string(nil), categories...)
+	var ls []int
+	for level := range levels {
+		ls = append(ls, level)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ls)))
+	for _, level := range ls {
+		all = append(all, levelBoard(level))
+	}
+	return all
+}
+
+// getLeader reads a user's entry on a board.
+func getLeader(tx *bolt.Tx, board, name string) (Leader, bool) {
+	var l Leader
+	keys := tx.Bucket(leaderKeys)
+	if keys == nil {
+		return l, false
+	}
+	key := keys.Get([]byte(board + "\x00" + strings.ToLower(name)))
+	if key == nil {
+		return l, false
+	}
+	val := tx.Bucket(leaderboards).Get(key)
+	return l, val != nil && json.Unmarshal(val, &l) == nil
+}
+
+// setLeader puts a user on a board at score, moving
+// their entry if they were on it.
+func setLeader(tx *bolt.Tx, board string, l Leader, score float64) error {
+	bucket, err := tx.CreateBucketIfNotExists(leaderboards)
+	if err != nil {
+		return err
+	}
+	keys, err := tx.CreateBucketIfNotExists(leaderKeys)
+	if err != nil {
+		return err
+	}
+	name := strings.ToLower(l.Name)
+	ptr := []byte(board + "\x00" + name)
+	if old := keys.Get(ptr); old != nil {
+		err = bucket.Delete(old)
+		if err != nil {
+			return err
+		}
+	}
+	// Scores are at least 0, in hundredths
+	inverted := math.MaxUint64 - uint64(math.Max(score, 0)*100)
+	key := []byte(board + "\x00")
+	var s [8]byte
+	binary.BigEndian.PutUint64(s[:], inverted)
+	key = append(append(key, s[:]...), name...)
+	val, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	err = bucket.Put(key, val)
+	if err != nil {
+		return err
+	}
+	return keys.Put(ptr, key)
+}
+
+// rateLeader updates a user's entry on the board of a
+// category after a rated game.
+func rateLeader(tx *bolt.Tx, name, category string, rt Rating, outcome string) error {
+	l, _ := getLeader(tx, category, name)
+	if u := getUser(tx, name); u != nil {
+		name = u.Name
+	}
+	l.Name, l.Rating, l.Deviation, l.Volatility = name, rt.Rating, rt.Deviation, rt.Volatility
+	l.Games, l.LastPlayed = rt.Games, rt.Updated
+	l.add(outcome, 1)
+	return setLeader(tx, category, l, rt.Rating)
+}
+
+// levelLeader counts a user's finished game against a
+// level on its board, or takes it away with n -1.
+func levelLeader(tx *bolt.Tx, name string, level int, outcome string, n int) error {
+	if _, ok := levels[level]; !ok {
+		return nil
+	}
+	u := getUser(tx, name)
+	if u == nil {
+		return nil // guests aren't on boards
+	}
+	board := levelBoard(level)
+	l, _ := getLeader(tx, board, name)
+	l.Name = u.Name
+	l.add(outcome, n)
+	l.Games += n
+	if n > 0 {
+		l.LastPlayed = time.Now()
+	}
+	return setLeader(tx, board, l, float64(l.Wins))
+}
+
+// add counts an outcome, n being 1 or -1.
+func (l *Leader) add(outcome string, n int) {
+	switch outcome {
+	case "win":
+		l.Wins += n
+	case "draw":
+		l.Draws += n
+	case "loss":
+		l.Losses += n
+	}
+}
+
+// BoardFilter is who a board leaves out.
+type BoardFilter struct {
+	MinGames     int
+	MaxDeviation float64 // rating boards
+	ActiveDays   int     // level boards, 0 for all
+}
+
+// filterOf returns the filter asked for by r's query,
+// min, maxrd and days.
+func filterOf(r *http.Request) BoardFilter {
+	f := BoardFilter{MinGames: defaultMinGames,
+		MaxDeviation: defaultMaxDeviation, ActiveDays: defaultActiveDays}
+	q := r.URL.Query()
+	if n, err := strconv.Atoi(q.Get("min")); err == nil && n >= 0 {
+		f.MinGames = n
+	}
+	if d, err := strconv.ParseFloat(q.Get("maxrd"), 64); err == nil && d > 0 {
+		f.MaxDeviation = d
+	}
+	if n, err := strconv.Atoi(q.Get("days")); err == nil && n >= 0 {
+		f.ActiveDays = n
+	}
+	return f
+}
+
+// readBoard returns the top of a board, best first,
+// nil for no such board. Deviations grow with time
+// away as in Glicko-2, which may leave a player out.
+func readBoard(board string, f BoardFilter, top int) *Board {
+	b := &Board{Name: board, Leaders: make([]Leader, 0)}
+	level, isLevel := boardLevel(board)
+	switch {
+	case isLevel:
+		b.Title = "Wins against " + levelName(level)
+		b.Filters = fmt.Sprintf("at least %d games", f.MinGames)
+		if f.ActiveDays > 0 {
+			b.Filters += fmt.Sprintf(", played in the last %d days", f.ActiveDays)
+		}
+	case validCategory(board):
+		b.Title = strings.Title(board) + " rating"
+		b.Filters = fmt.Sprintf("at least %d games, deviation at most %g",
+			f.MinGames, f.MaxDeviation)
+	default:
+		return nil
+	}
+	now := time.Now()
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(leaderboards)
+		if bucket == nil {
+			return nil
+		}
+		prefix := []byte(board + "\x00")
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)) &&
+			len(b.Leaders) < top; k, v = c.Next() {
+			var l Leader
+			if json.Unmarshal(v, &l) != nil || l.Games < f.MinGames {
+				continue
+			}
+			if isLevel {
+				if f.ActiveDays > 0 && now.Sub(l.LastPlayed) > time.Duration(f.ActiveDays)*24*time.Hour {
+					continue
+				}
+			} else {
+				rt := Rating{Deviation: l.Deviation, Volatility: l.Volatility,
+					Updated: l.LastPlayed}
+				l.Deviation = rt.idle(now).Deviation
+				if l.Deviation > f.MaxDeviation {
+					continue
+				}
+			}
+			l.Rank = len(b.Leaders) + 1
+			b.Leaders = append(b.Leaders, l)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return b
+}
+
+// rebuildLeaderboards fills the boards afresh unless
+// they were built at leadersVersion: the ratings with
+// the results of the rated games, and the games of each
+// user against the levels from their index.
+func rebuildLeaderboards() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if keys := tx.Bucket(leaderKeys); keys != nil &&
+			string(keys.Get(leadersVersionKey)) == leadersVersion {
+			return nil
+		}
+		for _, name := range [][]byte{leaderboards, leaderKeys} {
+			if tx.Bucket(name) != nil {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, err := tx.CreateBucket(leaderboards)
+		if err != nil {
+			return err
+		}
+		keys, err := tx.CreateBucket(leaderKeys)
+		if err != nil {
+			return err
+		}
+		err = keys.Put(leadersVersionKey, []byte(leadersVersion))
+		if err != nil {
+			return err
+		}
+		tallies := ratedTallies(tx)
+		if bucket := tx.Bucket(ratings); bucket != nil {
+			type rated struct {
+				key, name, category string
+				rt                  Rating
+			}
+			var rs []rated
+			bucket.ForEach(func(k, v []byte) error {
+				parts := strings.Split(string(k), "\x00")
+				var rt Rating
+				if len(parts) > 1 && json.Unmarshal(v, &rt) == nil {
+					rs = append(rs, rated{string(k), parts[0], parts[1], rt})
+				}
+				return nil
+			})
+			for _, r := range rs {
+				err = rateLeader(tx, r.name, r.category, r.rt, "")
+				if err != nil {
+					return err
+				}
+				t := tallies[r.key]
+				l, _ := getLeader(tx, r.category, r.name)
+				l.Wins, l.Draws, l.Losses = t.Wins, t.Draws, t.Losses
+				err = setLeader(tx, r.category, l, r.rt.Rating)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if bucket := tx.Bucket(seatGames); bucket != nil {
+			var games []GameSummary
+			var seats []string
+			bucket.ForEach(func(k, v []byte) error {
+				var s GameSummary
+				if json.Unmarshal(v, &s) == nil && s.Opponent == aiSeat && s.Outcome != "" {
+					games = append(games, s)
+					seats = append(seats, string(k[:strings.IndexByte(string(k), 0)]))
+				}
+				return nil
+			})
+			for i, s := range games {
+				err = levelLeader(tx, seats[i], s.Level, s.Outcome, 1)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ratedTallies counts the results of the finished rated
+// games by ratingKey, as rateGame counted them.
+func ratedTallies(tx *bolt.Tx) map[string]Tally {
+	tallies := make(map[string]Tally)
+	bucket := tx.Bucket(records)
+	if bucket == nil {
+		return tallies
+	}
+	count := func(name, category string, score float64) {
+		key := string(ratingKey(name, category))
+		t := tallies[key]
+		t.add(outcomeOf(score), 1)
+		tallies[key] = t
+	}
+	bucket.ForEach(func(k, v []byte) error {
+		var rec Record
+		if json.Unmarshal(v, &rec) != nil || !rec.Rated {
+			return nil
+		}
+		score, ok := resultScore(rec.Result)
+		if !ok {
+			return nil
+		}
+		category := rec.Category
+		if !validCategory(category) {
+			category = defaultCategory
+		}
+		white, okW := ratedSeat(tx, rec.White)
+		black, okB := ratedSeat(tx, rec.Black)
+		switch {
+		case rec.Kind == "ai":
+			if _, ok := levels[rec.Level]; !ok {
+				return nil
+			}
+			if rec.White == aiSeat {
+				white, okW, score = black, okB, 1-score
+			}
+			if okW {
+				count(white, category, score)
+			}
+		case okW && okB && !strings.EqualFold(white, black):
+			count(white, category, score)
+			count(black, category, 1-score)
+		}
+		return nil
+	})
+	return tallies
+}
+
+// leaderFuncs help the leaderboard templates.
+var leaderFuncs = template.FuncMap{
+	"round": func(f float64) int { return int(f + 0.5) },
+	"isLevel": func(board string) bool {
+		_, ok := boardLevel(board)
+		return ok
+	},
+}
+
+// showBoards executes the leaderboards template.
+func showBoards(w http.ResponseWriter, bs []*Board) {
+	t, err := template.New("leaderboards.html").Funcs(leaderFuncs).
+		ParseFiles("templates/leaderboards.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	err = t.Execute(w, bs)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// The top of every leaderboard
+func Leaderboards(w http.ResponseWriter,
+	r *http.Request) {
+	f := filterOf(r)
+	var bs []*Board
+	for _, board := range boards() {
+		bs = append(bs, readBoard(board, f, boardPreview))
+	}
+	showBoards(w, bs)
+}
+
+// A leaderboard, ?min= games, ?maxrd= deviation for
+// ratings and ?days= since playing a level
+func LeaderboardPage(w http.ResponseWriter,
+	r *http.Request) {
+	b := readBoard(mux.Vars(r)["board"], filterOf(r), boardTop)
+	if b == nil {
+		http.NotFound(w, r)
+		return
+	}
+	showBoards(w, []*Board{b})
+}
+
+// JSON leaderboard, filtered like LeaderboardPage
+func LeaderboardJson(w http.ResponseWriter,
+	r *http.Request) {
+	b := readBoard(mux.Vars(r)["board"], filterOf(r), boardTop)
+	w.Header().Set("Content-Type", "application/json")
+	if b == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such leaderboard"}`))
+		return
+	}
+	js, err := json.Marshal(b)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write(js)
+}
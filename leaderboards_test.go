@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Boards rebuilt from a database are the boards its
+// games made as they finished.
+func TestRebuildLeaderboards(t *testing.T) {
+	openTestDb(t)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := createUser(name, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recs := []Record{
+		{Id: "1", Kind: "challenge", White: "alice", Black: "bob", Result: "1-0"},
+		{Id: "2", Kind: "challenge", White: "bob", Black: "alice", Result: "1/2-1/2"},
+		{Id: "3", Kind: "ai", White: aiSeat, Black: "alice", Level: 3, Result: "0-1"},
+		{Id: "4", Kind: "ai", White: "bob", Black: aiSeat, Level: 3, Result: "0-1"},
+	}
+	for _, rec := range recs {
+		rec.Start, rec.Rated, rec.Category = startFen, true, "blitz"
+		err := db.Update(func(tx *bolt.Tx) error { return putRecord(tx, rec) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rateGame(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	boards := []string{"blitz", levelBoard(3)}
+	leaders := func() map[string]Leader {
+		m := make(map[string]Leader)
+		db.View(func(tx *bolt.Tx) error {
+			for _, board := range boards {
+				for _, name := range []string{"alice", "bob"} {
+					l, _ := getLeader(tx, board, name)
+					m[board+" "+name] = l
+				}
+			}
+			return nil
+		})
+		return m
+	}
+	played := leaders()
+	if l := played["blitz alice"]; l.Wins != 2 || l.Draws != 1 || l.Losses != 0 {
+		t.Fatalf("alice played %+v", l)
+	}
+	// Spoil alice's entry on the boards there are
+	spoil := func() {
+		t.Helper()
+		err := db.Update(func(tx *bolt.Tx) error {
+			l, _ := getLeader(tx, "blitz", "alice")
+			l.Wins = 0
+			return setLeader(tx, "blitz", l, l.Rating)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	spoil()
+	// Boards without the version are rebuilt
+	if err := rebuildLeaderboards(); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := leaders()
+	for k, l := range played {
+		r := rebuilt[k]
+		if r.Name != l.Name || r.Rating != l.Rating || r.Wins != l.Wins ||
+			r.Draws != l.Draws || r.Losses != l.Losses || r.Games != l.Games {
+			t.Errorf("%s: rebuilt %+v, played %+v", k, r, l)
+		}
+	}
+	// and then they're left alone
+	spoil()
+	if err := rebuildLeaderboards(); err != nil {
+		t.Fatal(err)
+	}
+	if l := leaders()["blitz alice"]; l.Wins != 0 {
+		t.Errorf("rebuilt boards of this version: %+v", l)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// matchOpenings are the default match openings, as
+// UCI moves from the start. Every one is played twice,
+// once with each configuration as white.
+var matchOpenings = []string{
+	"e2e4 e7e5 g1f3 b8c6 f1b5",
+	"e2e4 e7e5 g1f3 b8c6 f1c4",
+	"e2e4 c7c5 g1f3 d7d6",
+	"e2e4 c7c5 b1c3 b8c6",
+	"e2e4 e7e6 d2d4 d7d5",
+	"e2e4 c7c6 d2d4 d7d5",
+	"d2d4 d7d5 c2c4 e7e6",
+	"d2d4 d7d5 c2c4 c7c6",
+	"d2d4 g8f6 c2c4 g7g6",
+	"d2d4 g8f6 c2c4 e7e6 g1f3",
+	"c2c4 e7e5 b1c3",
+	"g1f3 d7d5 g2g3",
+}
+
+// matchPlayer is one configuration in a match.
+type matchPlayer struct {
+	Spec      string
+	Engine    Engine
+	Limits    Limits
+	Books     []*Book
+	BookPlies int
+}
+
+// parseMatchPlayer reads a configuration such as
+// "depth=4,time=500ms,book=a.bin+b.bin,bookplies=8,hash=16,threads=2,weights=w.json",
+// or "level=2,time=500ms" to play like a difficulty.
+// The engine is growser's search unless engine is
+// "minimax" or "uci:<path>", which runs size processes.
+// Every configuration has its own transposition table.
+func parseMatchPlayer(spec string, size int) (*matchPlayer, error) {
+	p := &matchPlayer{
+		Spec:      spec,
+		Limits:    Limits{Depth: 3},
+		BookPlies: 8,
+	}
+	hash := defaultHashMB
+	var weights *Weights
+	engine := "negamax"
+	for _, pair := range strings.Split(spec, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Invalid setting " + pair)
+		}
+		var err error
+		switch kv[0] {
+		case "level":
+			var diff int
+			diff, err = strconv.Atoi(kv[1])
+			if h, ok := levels[diff]; ok && err == nil {
+				p.Limits.Depth = h.Depth
+				p.Limits.Human = nil
+				if h.weakened() {
+					p.Limits.Human = h
+				}
+			} else if err == nil {
+				err = errors.New("Unknown level " + kv[1])
+			}
+		case "depth":
+			p.Limits.Depth, err = strconv.Atoi(kv[1])
+		case "time":
+			p.Limits.MoveTime, err = time.ParseDuration(kv[1])
+		case "bookplies":
+			p.BookPlies, err = strconv.Atoi(kv[1])
+		case "book":
+			for _, path := range strings.Split(kv[1], "+") {
+				b, err := LoadBook(path)
+				if err != nil {
+					return nil, err
+				}
+				p.Books = append(p.Books, b)
+			}
+		case "hash":
+			hash, err = strconv.Atoi(kv[1])
+		case "weights":
+			weights, err = LoadWeights(kv[1])
+		case "threads":
+			p.Limits.Threads, err = strconv.Atoi(kv[1])
+		case "engine":
+			engine = kv[1]
+		default:
+			err = errors.New("Unknown setting " + kv[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case engine == "negamax":
+		p.Engine = NegamaxEngine{Table: NewTransTable(hash), Weights: weights}
+	case engine == "minimax":
+		p.Engine = MiniMaxEngine{}
+	case strings.HasPrefix(engine, "uci:"):
+		p.Engine = NewUCIEngine(strings.TrimPrefix(engine, "uci:"), size)
+	default:
+		return nil, errors.New("Unknown engine " + engine)
+	}
+	return p, nil
+}
+
+// move picks the player's move in g, at ply.
+func (p *matchPlayer) move(g *ghess.Board, ply int) (Result, error) {
+	if ply < p.BookPlies {
+		if orig, dest, ok := pickBookMove(p.Books, g); ok {
+			return Result{Orig: orig, Dest: dest}, nil
+		}
+	}
+	res, err := p.Engine.BestMove(g, p.Limits)
+	if err == nil && res.Orig == 0 {
+		err = errors.New("No move found")
+	}
+	return res, err
+}
+
+// matchGame is a finished game of a match.
+type matchGame struct {
+	Round    int
+	AWhite   bool // configuration A played white
+	Start    string
+	Moves    []string // SAN
+	Result   string
+	Reason   string
+	Duration time.Duration
+}
+
+// score returns A's points from the game.
+func (m matchGame) score() float64 {
+	switch {
+	case m.Result == "1/2-1/2":
+		return 0.5
+	case (m.Result == "1-0") == m.AWhite:
+		return 1
+	}
+	return 0
+}
+
+// loadOpening sets up an opening, a FEN or UCI moves
+// from the start.
+func loadOpening(line string) (ghess.Board, error) {
+	if strings.Contains(line, "/") {
+		fields := strings.Fields(line)
+		// EPD has no clocks but may have operations
+		if len(fields) > 4 && strings.ContainsAny(line, ";") {
+			fields = fields[:4]
+		}
+		return loadFen(strings.Join(fields, " "))
+	}
+	game := ghess.NewBoard()
+	for _, mv := range strings.Fields(line) {
+		orig, dest, err := parseUciMove(&game, mv)
+		if err != nil {
+			return game, err
+		}
+		err = game.Move(orig, dest)
+		if err != nil {
+			return game, fmt.Errorf("%s: %s", mv, err)
+		}
+	}
+	return game, nil
+}
+
+// readOpenings reads a file of openings, one a line,
+// skipping blank lines and # comments.
+func readOpenings(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// playMatchGame plays the players, white first, from
+// start until the game ends or maxPlies is reached.
+func playMatchGame(white, black *matchPlayer, start ghess.Board, maxPlies int) matchGame {
+	began := time.Now()
+	game := start
+	m := matchGame{Start: standardFen(&game)}
+	players := map[string]*matchPlayer{"w": white, "b": black}
+	seen := make(map[string]int)
+	for {
+		turn := turnOf(&game)
+		// Repetitions, ignoring the clocks
+		key := strings.Join(strings.Fields(game.Position())[:4], " ")
+		seen[key]++
+		origs, _ := game.SearchValid()
+		switch {
+		case game.Checkmate || (len(origs) < 1 && game.Check):
+			m.Result, m.Reason = "1-0", "White mates"
+			if turn == "w" {
+				m.Result, m.Reason = "0-1", "Black mates"
+			}
+		case len(origs) < 1:
+			m.Result, m.Reason = "1/2-1/2", "Stalemate"
+		case game.Draw:
+			m.Result, m.Reason = "1/2-1/2", "Draw"
+		case seen[key] >= 3:
+			m.Result, m.Reason = "1/2-1/2", "Repetition"
+		case len(m.Moves) >= maxPlies:
+			m.Result, m.Reason = "1/2-1/2", "Adjudicated, too long"
+		}
+		if m.Result != "" {
+			break
+		}
+		res, err := players[turn].move(&game, len(m.Moves))
+		if err == nil {
+			m.Moves = append(m.Moves, san(&game, res.Orig, res.Dest))
+			err = game.Move(res.Orig, res.Dest)
+		}
+		if err != nil {
+			m.Result, m.Reason = "0-1", "White's engine failed: "+err.Error()
+			if turn == "b" {
+				m.Result, m.Reason = "1-0", "Black's engine failed: "+err.Error()
+			}
+			break
+		}
+	}
+	m.Duration = time.Since(began)
+	return m
+}
+
+// pgn writes the game, a and b are the configurations.
+func (m matchGame) pgn(a, b string) string {
+	white, black := a, b
+	if !m.AWhite {
+		white, black = b, a
+	}
+	y, mo, d := time.Now().Date()
+	headers := "[Event \"growser match\"]\n[Site \"growser\"]\n"
+	headers += fmt.Sprintf("[Date \"%d.%02d.%02d\"]\n", y, int(mo), d)
+	headers += fmt.Sprintf("[Round \"%d\"]\n", m.Round)
+	headers += "[White \"" + white + "\"]\n[Black \"" + black + "\"]\n"
+	headers += "[Result \"" + m.Result + "\"]\n"
+	headers += "[Termination \"" + m.Reason + "\"]\n"
+	if m.Start != startFen {
+		headers += "[SetUp \"1\"]\n[FEN \"" + m.Start + "\"]\n"
+	}
+	fields := strings.Fields(m.Start)
+	number, _ := strconv.Atoi(fields[5])
+	blackToMove := fields[1] == "b"
+	var movetext []string
+	for i, mv := range m.Moves {
+		if !blackToMove {
+			movetext = append(movetext, strconv.Itoa(number)+".")
+		} else if i == 0 {
+			movetext = append(movetext, strconv.Itoa(number)+"...")
+		}
+		movetext = append(movetext, mv)
+		if blackToMove {
+			number++
+		}
+		blackToMove = !blackToMove
+	}
+	movetext = append(movetext, m.Result)
+	return headers + "\n" + wrap(movetext, 79) + "\n"
+}
+
+// eloDiff returns the Elo difference of a player
+// scoring the fraction s.
+func eloDiff(s float64) float64 {
+	s = math.Max(math.Min(s, 0.999), 0.001)
+	return -400 * math.Log10(1/s-1)
+}
+
+// matchStats returns the score fraction of A, the Elo
+// difference and its 95% error margin.
+func matchStats(wins, draws, losses int) (float64, float64, float64) {
+	n := float64(wins + draws + losses)
+	if n == 0 {
+		return 0, 0, 0
+	}
+	s := (float64(wins) + float64(draws)/2) / n
+	variance := (float64(wins)*math.Pow(1-s, 2) +
+		float64(draws)*math.Pow(0.5-s, 2) +
+		float64(losses)*math.Pow(s, 2)) / n
+	margin := 1.96 * math.Sqrt(variance/n)
+	elo := eloDiff(s)
+	return s, elo, (eloDiff(s+margin) - eloDiff(s-margin)) / 2
+}
+
+// sprt returns the log likelihood ratio of elo1 over
+// elo0 given the results, with its lower and upper
+// bounds for the error rates alpha and beta.
+func sprt(wins, draws, losses int, elo0, elo1, alpha, beta float64) (float64, float64, float64) {
+	lower := math.Log(beta / (1 - alpha))
+	upper := math.Log((1 - beta) / alpha)
+	if wins+draws+losses == 0 {
+		return 0, lower, upper
+	}
+	// Half a game more of each result, so a match with
+	// no wins or no losses still has some variance
+	w, d, l := float64(wins)+0.5, float64(draws)+0.5, float64(losses)+0.5
+	n := w + d + l
+	s := (w + d/2) / n
+	variance := (w*math.Pow(1-s, 2) + d*math.Pow(0.5-s, 2) + l*math.Pow(s, 2)) / n
+	s0 := 1 / (1 + math.Pow(10, -elo0/400))
+	s1 := 1 / (1 + math.Pow(10, -elo1/400))
+	llr := n * (s1 - s0) * (2*s - s0 - s1) / (2 * variance)
+	return llr, lower, upper
+}
+
+// playMatch plays games between the players from starts,
+// concurrency at once, calling done with each game as
+// it finishes, one at a time.
+func playMatch(players [2]*matchPlayer, starts []ghess.Board, games, concurrency, maxPlies int, done func(matchGame)) {
+	jobs := make(chan int)
+	var mu sync.Mutex // guards done
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Every opening twice, colours swapped
+				aWhite := i%2 == 0
+				white, black := players[0], players[1]
+				if !aWhite {
+					white, black = black, white
+				}
+				m := playMatchGame(white, black, starts[(i/2)%len(starts)], maxPlies)
+				m.Round, m.AWhite = i+1, aWhite
+				mu.Lock()
+				done(m)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < games; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// MatchCommand runs "growser match", playing two
+// engine configurations against each other.
+func MatchCommand(args []string) {
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	a := flags.String("a", "depth=3", "configuration A, the candidate")
+	b := flags.String("b", "depth=2", "configuration B, the baseline")
+	games := flags.Int("games", 24, "games to play")
+	concurrency := flags.Int("concurrency", runtime.NumCPU(), "games played at once")
+	openings := flags.String("openings", "", "file of openings, FENs or UCI moves a line")
+	pgnPath := flags.String("pgn", "match.pgn", "where to write the games")
+	maxPlies := flags.Int("maxplies", 200, "plies before a game is drawn")
+	elo0 := flags.Float64("elo0", 0, "SPRT: Elo of A over B under H0")
+	elo1 := flags.Float64("elo1", 20, "SPRT: Elo of A over B under H1")
+	alpha := flags.Float64("alpha", 0.05, "SPRT: false positive rate")
+	beta := flags.Float64("beta", 0.05, "SPRT: false negative rate")
+	flags.Parse(args)
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	var players [2]*matchPlayer
+	for i, spec := range []string{*a, *b} {
+		p, err := parseMatchPlayer(spec, *concurrency)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer p.Engine.Close()
+		players[i] = p
+	}
+	lines := matchOpenings
+	if *openings != "" {
+		var err error
+		lines, err = readOpenings(*openings)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	starts := make([]ghess.Board, 0, len(lines))
+	for _, line := range lines {
+		g, err := loadOpening(line)
+		if err != nil {
+			fmt.Println("Opening", line+":", err)
+			os.Exit(1)
+		}
+		starts = append(starts, g)
+	}
+	if len(starts) < 1 {
+		fmt.Println("No openings")
+		os.Exit(1)
+	}
+
+	fmt.Printf("A: %s\nB: %s\n", players[0].Spec, players[1].Spec)
+	results := make([]matchGame, 0, *games)
+	var wins, draws, losses int
+	playMatch(players, starts, *games, *concurrency, *maxPlies, func(m matchGame) {
+		results = append(results, m)
+		switch m.score() {
+		case 1:
+			wins++
+		case 0.5:
+			draws++
+		default:
+			losses++
+		}
+		colour := "white"
+		if !m.AWhite {
+			colour = "black"
+		}
+		fmt.Printf("Game %d, A as %s: %s {%s} in %s, A +%d =%d -%d\n",
+			m.Round, colour, m.Result, m.Reason,
+			m.Duration.Round(time.Millisecond), wins, draws, losses)
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Round < results[j].Round
+	})
+	f, err := os.Create(*pgnPath)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		for _, m := range results {
+			fmt.Fprintln(f, m.pgn("A: "+players[0].Spec, "B: "+players[1].Spec))
+		}
+		f.Close()
+		fmt.Println("Games written to", *pgnPath)
+	}
+
+	s, elo, margin := matchStats(wins, draws, losses)
+	fmt.Printf("Score of A vs B: %d - %d - %d [%.3f] %d\n",
+		wins, losses, draws, s, len(results))
+	fmt.Printf("Elo difference: %.1f +/- %.1f\n", elo, margin)
+	llr, lower, upper := sprt(wins, draws, losses, *elo0, *elo1, *alpha, *beta)
+	verdict := "inconclusive, play more games"
+	switch {
+	case llr >= upper:
+		verdict = "H1 accepted, A is stronger"
+	case llr <= lower:
+		verdict = "H0 accepted, A is not stronger"
+	}
+	fmt.Printf("SPRT elo0=%g elo1=%g: LLR %.2f (%.2f, %.2f), %s\n",
+		*elo0, *elo1, llr, lower, upper, verdict)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSprt(t *testing.T) {
+	cases := []struct {
+		wins, draws, losses int
+		verdict             int // 1 accepts H1, -1 H0, 0 neither
+	}{
+		{0, 0, 0, 0},
+		{60, 0, 0, 1},
+		{0, 0, 60, -1},
+		{55, 10, 0, 1},
+		{5, 10, 5, 0},
+		{800, 400, 800, -1},
+	}
+	for _, c := range cases {
+		llr, lower, upper := sprt(c.wins, c.draws, c.losses, 0, 20, 0.05, 0.05)
+		verdict := 0
+		if llr >= upper {
+			verdict = 1
+		} else if llr <= lower {
+			verdict = -1
+		}
+		if verdict != c.verdict {
+			t.Errorf("+%d =%d -%d: llr %.2f in [%.2f, %.2f], want verdict %d",
+				c.wins, c.draws, c.losses, llr, lower, upper, c.verdict)
+		}
+	}
+}
+
+// Calibration chains each level's result against the
+// next one up down from the anchor.
+func TestCalibrate(t *testing.T) {
+	cals, err := calibrate("10ms", 2, 1, 6, 1600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cals) != len(levels) {
+		t.Fatalf("%d levels calibrated, want %d", len(cals), len(levels))
+	}
+	if top := cals[0]; top.Level != 5 || top.Rating != 1600 || top.Margin != 0 {
+		t.Errorf("anchor %+v", top)
+	}
+	for i, c := range cals[1:] {
+		above := cals[i]
+		if c.Against != above.Level || c.Level != above.Level-1 || c.Games != 2 {
+			t.Errorf("%+v after %+v", c, above)
+		}
+		if want := above.Rating + eloDiff(c.Score); math.Abs(c.Rating-want) > 1e-6 {
+			t.Errorf("level %d rated %.1f, want %.1f", c.Level, c.Rating, want)
+		}
+		if c.Margin < above.Margin {
+			t.Errorf("level %d margin %.1f below the level above's", c.Level, c.Margin)
+		}
+	}
+}
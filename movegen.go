@@ -0,0 +1,276 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// move is a move of a Position: the from and to
+// squares, the kind promoted to and a flag.
+type move uint32
+
+// Move flags
+const (
+	flagNone = iota
+	flagDouble
+	flagEnPassant
+	flagCastle
+)
+
+func newMove(from, to, promo, flag int) move {
+	return move(from | to<<6 | promo<<12 | flag<<15)
+}
+
+func (m move) from() int      { return int(m & 63) }
+func (m move) to() int        { return int(m>>6) & 63 }
+func (m move) promotion() int { return int(m>>12) & 7 }
+func (m move) flag() int      { return int(m>>15) & 3 }
+
+// String writes m in UCI notation, eg "e7e8q".
+func (m move) String() string {
+	s := squareName(m.from()) + squareName(m.to())
+	if promo := m.promotion(); promo != 0 {
+		s += string(pieceLetters[6+promo])
+	}
+	return s
+}
+
+// ghessMove returns m in ghess coordinates, castles
+// being the king onto its rook.
+func (m move) ghessMove() (int, int) {
+	to := m.to()
+	if m.flag() == flagCastle {
+		to, _ = castleRook(to)
+	}
+	return ghessSquare(m.from()), ghessSquare(to)
+}
+
+// moveOf finds the legal move of ghess coordinates
+// orig to dest, promoting to a queen like ghess.
+func (p *Position) moveOf(orig, dest int) (move, error) {
+	for _, m := range p.legalMoves(nil) {
+		o, d := m.ghessMove()
+		if o == orig && d == dest &&
+			(m.promotion() == 0 || m.promotion() == Queen) {
+			return m, nil
+		}
+	}
+	return 0, errIllegalMove
+}
+
+// parseMove finds the legal move of a UCI move.
+func (p *Position) parseMove(s string) (move, error) {
+	for _, m := range p.legalMoves(nil) {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, errIllegalMove
+}
+
+// parseSan finds the legal move of a move in SAN, eg
+// "Nbd7", "exd8=Q+" or "O-O".
+func (p *Position) parseSan(s string) (move, error) {
+	s = strings.TrimRight(s, "+#!?")
+	s = strings.Replace(s, "0", "O", -1)
+	kind, promo := Pawn, 0
+	castle := 0
+	switch s {
+	case "O-O":
+		castle = 6 // the file the king goes to
+	case "O-O-O":
+		castle = 2
+	default:
+		if i := strings.IndexAny(s, "QRBN"); i > 0 {
+			// "=Q" or a bare "Q" after the square
+			promo = strings.IndexByte(pieceLetters, s[i])
+			s = strings.TrimRight(s[:i], "=")
+		}
+		if len(s) > 0 && strings.IndexByte("NBRQK", s[0]) >= 0 {
+			kind = strings.IndexByte(pieceLetters, s[0])
+			s = s[1:]
+		}
+		s = strings.Replace(s, "x", "", -1)
+		if len(s) < 2 {
+			return 0, errIllegalMove
+		}
+	}
+	for _, m := range p.legalMoves(nil) {
+		if castle != 0 {
+			if m.flag() == flagCastle && m.to()%8 == castle {
+				return m, nil
+			}
+			continue
+		}
+		if p.board[m.from()]%6 != kind || m.promotion() != promo ||
+			squareName(m.to()) != s[len(s)-2:] {
+			continue
+		}
+		// What's left tells the file or rank it's from
+		from := squareName(m.from())
+		ok := true
+		for _, c := range s[:len(s)-2] {
+			if !strings.ContainsRune(from, c) {
+				ok = false
+			}
+		}
+		if ok {
+			return m, nil
+		}
+	}
+	return 0, errIllegalMove
+}
+
+// addPawnMoves adds the moves of a pawn from to to,
+// all four promotions on the last rank.
+func addPawnMoves(moves []move, from, to, flag int) []move {
+	if to >= 56 || to < 8 {
+		for _, promo := range []int{Queen, Knight, Rook, Bishop} {
+			moves = append(moves, newMove(from, to, promo, flag))
+		}
+		return moves
+	}
+	return append(moves, newMove(from, to, 0, flag))
+}
+
+// addMoves adds a move from to every square of targets.
+func addMoves(moves []move, from int, targets uint64) []move {
+	for targets != 0 {
+		to := bits.TrailingZeros64(targets)
+		targets &= targets - 1
+		moves = append(moves, newMove(from, to, 0, flagNone))
+	}
+	return moves
+}
+
+// pseudoMoves appends the moves of the side to move,
+// ignoring whether they leave its king in check.
+func (p *Position) pseudoMoves(moves []move) []move {
+	us, them := p.side, 1-p.side
+	own, enemy := p.occupied[us], p.occupied[them]
+	occ := own | enemy
+
+	// Pawns
+	push, startRank := 8, 1
+	if us == Black {
+		push, startRank = -8, 6
+	}
+	for pawns := p.pieces[us][Pawn]; pawns != 0; pawns &= pawns - 1 {
+		from := bits.TrailingZeros64(pawns)
+		to := from + push
+		if occ&(1<<uint(to)) == 0 {
+			moves = addPawnMoves(moves, from, to, flagNone)
+			if from/8 == startRank && occ&(1<<uint(to+push)) == 0 {
+				moves = append(moves, newMove(from, to+push, 0, flagDouble))
+			}
+		}
+		for caps := pawnAttacks[us][from] & enemy; caps != 0; caps &= caps - 1 {
+			moves = addPawnMoves(moves, from, bits.TrailingZeros64(caps), flagNone)
+		}
+		if p.ep != NoSquare && pawnAttacks[us][from]&(1<<uint(p.ep)) != 0 {
+			moves = append(moves, newMove(from, p.ep, 0, flagEnPassant))
+		}
+	}
+	for n := p.pieces[us][Knight]; n != 0; n &= n - 1 {
+		from := bits.TrailingZeros64(n)
+		moves = addMoves(moves, from, knightAttacks[from]&^own)
+	}
+	for b := p.pieces[us][Bishop] | p.pieces[us][Queen]; b != 0; b &= b - 1 {
+		from := bits.TrailingZeros64(b)
+		moves = addMoves(moves, from, slide(from, occ, bishopDirs)&^own)
+	}
+	for r := p.pieces[us][Rook] | p.pieces[us][Queen]; r != 0; r &= r - 1 {
+		from := bits.TrailingZeros64(r)
+		moves = addMoves(moves, from, slide(from, occ, rookDirs)&^own)
+	}
+	from := p.kingSquare(us)
+	moves = addMoves(moves, from, kingAttacks[from]&^own)
+
+	// Castles, the king may not pass through check
+	base, kingSide, queenSide := 0, castleWK, castleWQ
+	if us == Black {
+		base, kingSide, queenSide = 56, castleBK, castleBQ
+	}
+	if p.castle&kingSide != 0 && occ&(3<<uint(base+5)) == 0 &&
+		!p.attacked(base+4, them) && !p.attacked(base+5, them) &&
+		!p.attacked(base+6, them) {
+		moves = append(moves, newMove(base+4, base+6, 0, flagCastle))
+	}
+	if p.castle&queenSide != 0 && occ&(7<<uint(base+1)) == 0 &&
+		!p.attacked(base+4, them) && !p.attacked(base+3, them) &&
+		!p.attacked(base+2, them) {
+		moves = append(moves, newMove(base+4, base+2, 0, flagCastle))
+	}
+	return moves
+}
+
+// legalMoves appends the legal moves of the side to move.
+func (p *Position) legalMoves(moves []move) []move {
+	start := len(moves)
+	moves = p.pseudoMoves(moves)
+	legal := moves[:start]
+	us := p.side
+	for _, m := range moves[start:] {
+		p.makeMove(m)
+		if !p.attacked(p.kingSquare(us), 1-us) {
+			legal = append(legal, m)
+		}
+		p.unmakeMove()
+	}
+	return legal
+}
+
+// isCapture returns true if m takes a piece in p.
+func (p *Position) isCapture(m move) bool {
+	return p.board[m.to()] != Empty || m.flag() == flagEnPassant
+}
+
+// tacticalMoves appends the legal captures and
+// promotions of the side to move, for quiescence.
+func (p *Position) tacticalMoves(moves []move) []move {
+	start := len(moves)
+	moves = p.pseudoMoves(moves)
+	tactical := moves[:start]
+	us := p.side
+	for _, m := range moves[start:] {
+		if !p.isCapture(m) && m.promotion() == 0 {
+			continue
+		}
+		p.makeMove(m)
+		if !p.attacked(p.kingSquare(us), 1-us) {
+			tactical = append(tactical, m)
+		}
+		p.unmakeMove()
+	}
+	return tactical
+}
+
+// perft counts the leaves of the move tree to depth.
+func (p *Position) perft(depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	var buf [256]move
+	moves := p.legalMoves(buf[:0])
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for _, m := range moves {
+		p.makeMove(m)
+		nodes += p.perft(depth - 1)
+		p.unmakeMove()
+	}
+	return nodes
+}
+
+// divide returns the perft of every root move.
+func (p *Position) divide(depth int) map[string]int {
+	counts := make(map[string]int)
+	for _, m := range p.legalMoves(nil) {
+		p.makeMove(m)
+		counts[m.String()] = p.perft(depth - 1)
+		p.unmakeMove()
+	}
+	return counts
+}
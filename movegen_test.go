@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+// unmakeMove puts every move of the suite back as it was.
+func TestMakeUnmake(t *testing.T) {
+	for _, c := range perftSuite {
+		p, err := ParseFen(c.Fen)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		fen, hash := p.Fen(), p.hash
+		for _, m := range p.legalMoves(nil) {
+			p.makeMove(m)
+			p.unmakeMove()
+			if p.Fen() != fen || p.hash != hash {
+				t.Errorf("%s: %s left %s", c.Name, m, p.Fen())
+			}
+		}
+	}
+}
+
+// The Fen written is the Fen read, for the standard
+// positions which have the move counters.
+func TestFenRoundTrip(t *testing.T) {
+	for _, c := range perftSuite[:7] {
+		p, err := ParseFen(c.Fen)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		if p.Fen() != c.Fen {
+			t.Errorf("%s: wrote %s", c.Name, p.Fen())
+		}
+	}
+}
+
+func benchmarkPerft(b *testing.B, gen string) {
+	for _, pos := range benchPositions {
+		b.Run(pos.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := perftOf(pos.Fen, pos.Depth, gen, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPerftBitboard(b *testing.B) { benchmarkPerft(b, "bitboard") }
+
+func BenchmarkPerftGhess(b *testing.B) { benchmarkPerft(b, "ghess") }
+
+func BenchmarkLegalMoves(b *testing.B) {
+	p, err := ParseFen(perftSuite[1].Fen)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buf [256]move
+	for i := 0; i < b.N; i++ {
+		p.legalMoves(buf[:0])
+	}
+}
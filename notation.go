@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/polypmer/ghess"
+)
+
+// moveString writes a move in coordinate notation,
+// eg "e2e4". Castles are written as the king moving
+// onto the rook, as ghess plays them, eg "e1h1".
+func moveString(orig, dest int) string {
+	return ghess.PieceMap[orig] + ghess.PieceMap[dest]
+}
+
+// parseMoveString reads a move in coordinate notation.
+// A promotion suffix is accepted, but ghess always
+// promotes to a queen.
+func parseMoveString(mv string) (int, int, error) {
+	if len(mv) != 4 && len(mv) != 5 {
+		return 0, 0, errors.New("Invalid move " + mv)
+	}
+	orig, ok := ghess.PgnToCoordMap[mv[0:2]]
+	if !ok {
+		return 0, 0, errors.New("Invalid move " + mv)
+	}
+	dest, ok := ghess.PgnToCoordMap[mv[2:4]]
+	if !ok {
+		return 0, 0, errors.New("Invalid move " + mv)
+	}
+	return orig, dest, nil
+}
+
+// san writes the move orig to dest on g in standard
+// algebraic notation, eg "Nbd2", "exd5", "O-O" or "Qh7#".
+// The move is assumed to be valid.
+func san(g *ghess.Board, orig, dest int) string {
+	arr := boardOf(g)
+	p := lower(arr[orig])
+	isWhite := arr[orig] <= 'Z'
+	var mv string
+	switch {
+	case p == 'k' && lower(arr[dest]) == 'r' &&
+		(arr[dest] <= 'Z') == isWhite:
+		// Castle onto the rook, the a file is the queen side
+		if dest%10 == 8 {
+			mv = "O-O-O"
+		} else {
+			mv = "O-O"
+		}
+	case p == 'p':
+		if orig%10 != dest%10 {
+			mv = ghess.PieceMap[orig][0:1] + "x"
+		}
+		mv += ghess.PieceMap[dest]
+		if dest > 80 || dest < 20 {
+			mv += "=Q"
+		}
+	default:
+		mv = string(p - 'a' + 'A')
+		// Disambiguate between the same pieces
+		origs, dests := g.SearchValid()
+		var sameFile, sameRank, other bool
+		for i := 0; i < len(origs); i++ {
+			if dests[i] != dest || origs[i] == orig ||
+				arr[origs[i]] != arr[orig] {
+				continue
+			}
+			other = true
+			if origs[i]%10 == orig%10 {
+				sameFile = true
+			}
+			if origs[i]/10 == orig/10 {
+				sameRank = true
+			}
+		}
+		switch {
+		case other && !sameFile:
+			mv += ghess.PieceMap[orig][0:1]
+		case other && !sameRank:
+			mv += ghess.PieceMap[orig][1:2]
+		case other:
+			mv += ghess.PieceMap[orig]
+		}
+		if arr[dest] != '.' {
+			mv += "x"
+		}
+		mv += ghess.PieceMap[dest]
+	}
+	next := ghess.CopyBoard(g)
+	if err := next.Move(orig, dest); err == nil {
+		if next.Checkmate {
+			mv += "#"
+		} else if next.Check {
+			mv += "+"
+		}
+	}
+	return mv
+}
+
+// standardFen writes the position of g as a standard
+// FEN, ghess keeps '-' for each lost castling right.
+func standardFen(g *ghess.Board) string {
+	fields := strings.Split(g.Position(), " ")
+	castle := strings.Replace(fields[2], "-", "", -1)
+	if castle == "" {
+		castle = "-"
+	}
+	fields[2] = castle
+	return strings.Join(fields, " ")
+}
+
+// ghessFen rewrites a standard FEN into one ghess
+// will load. Ghess only reads a single digit half move
+// clock and two digit move numbers.
+func ghessFen(fen string) (string, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return fen, errors.New("Invalid FEN")
+	}
+	for len(fields) < 6 {
+		fields = append(fields, "0")
+	}
+	fields[4] = "0"
+	moves, err := strconv.Atoi(fields[5])
+	if err != nil || moves < 1 {
+		moves = 1
+	}
+	if moves > 99 {
+		moves = 99
+	}
+	fields[5] = strconv.Itoa(moves)
+	return strings.Join(fields[:6], " "), nil
+}
+
+// loadFen sets up a new Board from a standard FEN.
+func loadFen(fen string) (ghess.Board, error) {
+	game := ghess.NewBoard()
+	fen, err := ghessFen(fen)
+	if err != nil {
+		return game, err
+	}
+	err = game.LoadFen(fen)
+	return game, err
+}
+
+// uciMove writes a move in UCI notation, where castles
+// are the king moving two squares, unlike ghess.
+func uciMove(g *ghess.Board, orig, dest int) string {
+	arr := boardOf(g)
+	mv := moveString(orig, dest)
+	if lower(arr[orig]) == 'k' && lower(arr[dest]) == 'r' &&
+		(arr[orig] <= 'Z') == (arr[dest] <= 'Z') {
+		if dest < orig { // King side
+			mv = moveString(orig, orig-2)
+		} else {
+			mv = moveString(orig, orig+2)
+		}
+	} else if lower(arr[orig]) == 'p' && (dest > 80 || dest < 20) {
+		mv += "q"
+	}
+	return mv
+}
+
+// parseUciMove reads a move in UCI notation into ghess
+// coordinates, castles become the king onto the rook.
+func parseUciMove(g *ghess.Board, mv string) (int, int, error) {
+	orig, dest, err := parseMoveString(mv)
+	if err != nil {
+		return orig, dest, err
+	}
+	arr := boardOf(g)
+	if (orig == 14 && arr[orig] == 'K') || (orig == 84 && arr[orig] == 'k') {
+		switch dest - orig {
+		case -2:
+			dest = orig - 3
+		case 2:
+			dest = orig + 4
+		}
+	}
+	return orig, dest, nil
+}
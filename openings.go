@@ -0,0 +1,97 @@
+package main
+
+import "strings"
+
+// openings name the common openings by their moves
+// from the start, as a Record keeps them. The longest
+// which a game starts with names it.
+var openings = map[string]string{
+	"e2e4":                                              "King's Pawn Opening",
+	"e2e4 e7e5":                                         "Open Game",
+	"e2e4 e7e5 g1f3":                                    "King's Knight Opening",
+	"e2e4 e7e5 g1f3 b8c6 f1b5":                          "Ruy Lopez",
+	"e2e4 e7e5 g1f3 b8c6 f1b5 a7a6":                     "Ruy Lopez: Morphy Defence",
+	"e2e4 e7e5 g1f3 b8c6 f1b5 g8f6":                     "Ruy Lopez: Berlin Defence",
+	"e2e4 e7e5 g1f3 b8c6 f1c4":                          "Italian Game",
+	"e2e4 e7e5 g1f3 b8c6 f1c4 f8c5":                     "Giuoco Piano",
+	"e2e4 e7e5 g1f3 b8c6 f1c4 f8c5 b2b4":                "Evans Gambit",
+	"e2e4 e7e5 g1f3 b8c6 f1c4 g8f6":                     "Two Knights Defence",
+	"e2e4 e7e5 g1f3 b8c6 d2d4":                          "Scotch Game",
+	"e2e4 e7e5 g1f3 b8c6 b1c3":                          "Three Knights Opening",
+	"e2e4 e7e5 g1f3 b8c6 b1c3 g8f6":                     "Four Knights Game",
+	"e2e4 e7e5 g1f3 g8f6":                               "Petrov's Defence",
+	"e2e4 e7e5 g1f3 d7d6":                               "Philidor Defence",
+	"e2e4 e7e5 f2f4":                                    "King's Gambit",
+	"e2e4 e7e5 f2f4 e5f4":                               "King's Gambit Accepted",
+	"e2e4 e7e5 b1c3":                                    "Vienna Game",
+	"e2e4 e7e5 f1c4":                                    "Bishop's Opening",
+	"e2e4 e7e5 d2d4":                                    "Centre Game",
+	"e2e4 c7c5":                                         "Sicilian Defence",
+	"e2e4 c7c5 g1f3 d7d6":                               "Sicilian Defence",
+	"e2e4 c7c5 g1f3 d7d6 d2d4 c5d4 f3d4 g8f6 b1c3 a7a6": "Sicilian Defence: Najdorf Variation",
+	"e2e4 c7c5 g1f3 d7d6 d2d4 c5d4 f3d4 g8f6 b1c3 g7g6": "Sicilian Defence: Dragon Variation",
+	"e2e4 c7c5 g1f3 b8c6":                               "Sicilian Defence: Old Sicilian",
+	"e2e4 c7c5 g1f3 e7e6":                               "Sicilian Defence: French Variation",
+	"e2e4 c7c5 b1c3":                                    "Sicilian Defence: Closed",
+	"e2e4 c7c5 c2c3":                                    "Sicilian Defence: Alapin Variation",
+	"e2e4 c7c5 d2d4 c5d4 c2c3":                          "Sicilian Defence: Smith-Morra Gambit",
+	"e2e4 e7e6":                                         "French Defence",
+	"e2e4 e7e6 d2d4 d7d5 e4e5":                          "French Defence: Advance Variation",
+	"e2e4 e7e6 d2d4 d7d5 e4d5":                          "French Defence: Exchange Variation",
+	"e2e4 e7e6 d2d4 d7d5 b1d2":                          "French Defence: Tarrasch Variation",
+	"e2e4 e7e6 d2d4 d7d5 b1c3 f8b4":                     "French Defence: Winawer Variation",
+	"e2e4 c7c6":                                         "Caro-Kann Defence",
+	"e2e4 c7c6 d2d4 d7d5 e4e5":                          "Caro-Kann Defence: Advance Variation",
+	"e2e4 c7c6 d2d4 d7d5 b1c3 d5e4 c3e4":                "Caro-Kann Defence: Main Line",
+	"e2e4 d7d5":                                         "Scandinavian Defence",
+	"e2e4 g8f6":                                         "Alekhine's Defence",
+	"e2e4 d7d6":                                         "Pirc Defence",
+	"e2e4 g7g6":                                         "Modern Defence",
+	"e2e4 b8c6":                                         "Nimzowitsch Defence",
+	"d2d4":                                              "Queen's Pawn Opening",
+	"d2d4 d7d5":                                         "Queen's Pawn Game",
+	"d2d4 d7d5 c2c4":                                    "Queen's Gambit",
+	"d2d4 d7d5 c2c4 d5c4":                               "Queen's Gambit Accepted",
+	"d2d4 d7d5 c2c4 e7e6":                               "Queen's Gambit Declined",
+	"d2d4 d7d5 c2c4 c7c6":                               "Slav Defence",
+	"d2d4 d7d5 c2c4 e7e5":                               "Albin Countergambit",
+	"d2d4 d7d5 c1f4":                                    "London System",
+	"d2d4 g8f6 c1f4":                                    "London System",
+	"d2d4 g8f6":                                         "Indian Defence",
+	"d2d4 g8f6 c2c4 e7e6 b1c3 f8b4":                     "Nimzo-Indian Defence",
+	"d2d4 g8f6 c2c4 e7e6 g1f3 b7b6":                     "Queen's Indian Defence",
+	"d2d4 g8f6 c2c4 e7e6 g2g3":                          "Catalan Opening",
+	"d2d4 g8f6 c2c4 g7g6":                               "King's Indian Defence",
+	"d2d4 g8f6 c2c4 g7g6 b1c3 d7d5":                     "Grünfeld Defence",
+	"d2d4 g8f6 c2c4 c7c5":                               "Benoni Defence",
+	"d2d4 g8f6 c2c4 c7c5 d4d5 b7b5":                     "Benko Gambit",
+	"d2d4 f7f5":                                         "Dutch Defence",
+	"c2c4":                                              "English Opening",
+	"c2c4 e7e5":                                         "English Opening: Reversed Sicilian",
+	"g1f3":                                              "Zukertort Opening",
+	"g1f3 d7d5 c2c4":                                    "Réti Opening",
+	"f2f4":                                              "Bird's Opening",
+	"b2b3":                                              "Nimzo-Larsen Attack",
+	"g2g3":                                              "Hungarian Opening",
+}
+
+// openingPlies is as many as the longest of openings.
+const openingPlies = 10
+
+// openingName returns the name of the opening of a
+// game from the start, empty if it isn't known.
+func openingName(start string, moves []string) string {
+	if start != "" && start != startFen {
+		return ""
+	}
+	n := len(moves)
+	if n > openingPlies {
+		n = openingPlies
+	}
+	for ; n > 0; n-- {
+		if name, ok := openings[strings.Join(moves[:n], " ")]; ok {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Forbidden is the JSON answer to a move, hint or coach
+// request in an AI game the player doesn't own.
+type Forbidden struct {
+	Position string `json:"position,omitempty"` // to snap the board back
+	Message  string `json:"message"`
+	GameId   string `json:"id"`
+	Error    bool   `json:"error"`
+}
+
+// humanSeat returns the seat of the human in an AI
+// game, empty for games from before seats.
+func (rec Record) humanSeat() string {
+	if rec.White == aiSeat {
+		return rec.Black
+	}
+	return rec.White
+}
+
+// predatesSeats returns true for an AI game made before
+// Records had seats, which nobody owns yet.
+func (rec Record) predatesSeats() bool {
+	return rec.White == "" && rec.Black == ""
+}
+
+// sitsIn returns true if r's player sits in seat, by
+// their login or their guest cookie, so a guest who
+// logs in keeps the games they made before.
+func sitsIn(r *http.Request, seat string) bool {
+	if seat == "" {
+		return false
+	}
+	if u := userFrom(r); u != nil && u.Name == seat {
+		return true
+	}
+	g := guestFrom(r)
+	return g != "" && seat == guestPrefix+g
+}
+
+// ownsGame returns true if r's player may play the AI
+// game of rec. Nobody owns a game from before seats,
+// the first to move in it takes it.
+func ownsGame(r *http.Request, rec Record) bool {
+	return rec.predatesSeats() || sitsIn(r, rec.humanSeat())
+}
+
+// forbidGame answers a request in someone else's game.
+func forbidGame(w http.ResponseWriter, id, pos string) {
+	js, err := json.Marshal(Forbidden{
+		Position: pos,
+		Message:  "> This isn't your game,<br><br><i>you can only watch it</i>",
+		GameId:   id,
+		Error:    true,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(js)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+func TestCoachGame(t *testing.T) {
+	openTestDb(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(games)
+		if err != nil {
+			return err
+		}
+		for _, rec := range []Record{
+			{Id: "mine", Kind: "ai", White: guestPrefix + "me", Black: aiSeat},
+			{Id: "old", Kind: "ai"},
+			{Id: "noseat", Kind: "ai", White: aiSeat},
+		} {
+			err = bucket.Put([]byte(rec.Id), []byte(startFen))
+			if err != nil {
+				return err
+			}
+			err = putRecord(tx, rec)
+			if err != nil {
+				return err
+			}
+		}
+		// A game without its Record
+		return bucket.Put([]byte("norecord"), []byte(startFen))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/coach/{id}/{on}", CoachGame)
+	cases := []struct {
+		id, guest string
+		code      int
+	}{
+		{"mine", "me", http.StatusOK},
+		{"mine", "you", http.StatusForbidden},
+		{"old", "you", http.StatusOK},
+		{"noseat", "me", http.StatusForbidden},
+		{"norecord", "me", http.StatusNotFound},
+		{"nogame", "me", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/coach/"+c.id+"/true", nil)
+		r = r.WithContext(context.WithValue(r.Context(), guestKey{}, c.guest))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s as %s: %d, want %d", c.id, c.guest, w.Code, c.code)
+		}
+	}
+}
+
+// Games made at once get their own ids, past any in use.
+func TestNewGameIds(t *testing.T) {
+	openTestDb(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(games)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("1"), []byte(startFen))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/new/{player}", NewGame)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/new/white", nil)
+		r = r.WithContext(context.WithValue(r.Context(), guestKey{}, "me"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		loc := w.Header().Get("Location")
+		if loc == "/view/1" || seen[loc] {
+			t.Errorf("game %d made at %s again", i, loc)
+		}
+		seen[loc] = true
+	}
+}
+
+// Challenges made back to back get their own ids, past
+// those of AI games, whose Records they share.
+func TestNewChallengeIds(t *testing.T) {
+	openTestDb(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(games)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte("1"), []byte(startFen))
+		if err != nil {
+			return err
+		}
+		return putRecord(tx, Record{Id: "1", Kind: "ai", Start: startFen})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/challenge", NewChallenge)
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", "/challenge", nil)
+		r = r.WithContext(context.WithValue(r.Context(), guestKey{}, "me"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		loc := w.Header().Get("Location")
+		if loc == "/challenge/1" || seen[loc] {
+			t.Errorf("challenge %d made at %s again", i, loc)
+		}
+		seen[loc] = true
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if rec := getRecord(tx, "1"); rec.Kind != "ai" {
+			t.Errorf("the AI game's record is now a %s's", rec.Kind)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import "sort"
+
+// pairingBudget is how many pairings the Swiss search
+// tries before it allows rematches, which only happens
+// when there's no pairing without one.
+const pairingBudget = 100000
+
+// Colour preferences, from none to one which must be
+// granted, as in the Dutch system.
+const (
+	noPreference = iota
+	mildPreference
+	strongPreference
+	absolutePreference
+)
+
+// colourPreference returns the colour a player wants
+// next, 'w' or 'b', and how much, from the colours they
+// played so far, eg "wbw".
+func colourPreference(colours string) (byte, int) {
+	n := len(colours)
+	if n == 0 {
+		return 0, noPreference
+	}
+	diff := 0
+	for i := 0; i < n; i++ {
+		if colours[i] == 'w' {
+			diff++
+		} else {
+			diff--
+		}
+	}
+	switch {
+	case diff < -1 || n > 1 && colours[n-2:] == "bb":
+		return 'w', absolutePreference
+	case diff > 1 || n > 1 && colours[n-2:] == "ww":
+		return 'b', absolutePreference
+	case diff < 0:
+		return 'w', strongPreference
+	case diff > 0:
+		return 'b', strongPreference
+	}
+	if colours[n-1] == 'w' {
+		return 'b', mildPreference
+	}
+	return 'w', mildPreference
+}
+
+// mayMeet returns true if a and b may be paired, not
+// having met and not both having to have one colour.
+// relaxed lets anybody meet.
+func mayMeet(a, b *Standing, relaxed bool) bool {
+	if relaxed {
+		return true
+	}
+	if a.opponents[b.Name] {
+		return false
+	}
+	ca, pa := colourPreference(a.colours)
+	cb, pb := colourPreference(b.colours)
+	return !(pa == absolutePreference && pb == absolutePreference && ca == cb)
+}
+
+// allocate returns a Pairing of a, the higher placed,
+// and b on board, granting the stronger colour
+// preference, or the higher placed's if they're alike.
+// In the first round the higher placed are white on
+// the odd boards.
+func allocate(a, b *Standing, board int) Pairing {
+	ca, pa := colourPreference(a.colours)
+	cb, pb := colourPreference(b.colours)
+	aWhite := board%2 == 1
+	switch {
+	case pa == noPreference && pb == noPreference:
+	case pb == noPreference, ca != cb && pa != noPreference, pa > pb:
+		aWhite = ca == 'w'
+	case pa == noPreference, pb > pa:
+		aWhite = cb == 'b'
+	default:
+		aWhite = ca == 'w'
+	}
+	if aWhite {
+		return Pairing{Board: board, White: a.Name, Black: b.Name}
+	}
+	return Pairing{Board: board, White: b.Name, Black: a.Name}
+}
+
+// swissPairings pairs the next round of a Swiss in
+// the manner of the Dutch system: players are placed
+// by score then seed, and in each score group the top
+// half meets the bottom half in order, the first
+// player able to be paired being preferred, those left
+// over floating down. An odd player out gets a bye,
+// the lowest placed who hasn't had one.
+func swissPairings(standings []Standing) []Pairing {
+	ps := make([]*Standing, len(standings))
+	for i := range standings {
+		ps[i] = &standings[i]
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Points != ps[j].Points {
+			return ps[i].Points > ps[j].Points
+		}
+		return ps[i].Seed < ps[j].Seed
+	})
+	for _, relaxed := range []bool{false, true} {
+		if len(ps)%2 == 0 {
+			if pairs, ok := pairSwiss(ps, relaxed); ok {
+				return boardsOf(pairs, "")
+			}
+			continue
+		}
+		for bye := len(ps) - 1; bye >= 0; bye-- {
+			if ps[bye].bye && !relaxed {
+				continue
+			}
+			rest := append(append([]*Standing(nil), ps[:bye]...), ps[bye+1:]...)
+			if pairs, ok := pairSwiss(rest, relaxed); ok {
+				return boardsOf(pairs, ps[bye].Name)
+			}
+		}
+	}
+	return nil
+}
+
+// pairSwiss pairs ps, in order, see swissPairings.
+func pairSwiss(ps []*Standing, relaxed bool) ([][2]*Standing, bool) {
+	budget := pairingBudget
+	var pairs [][2]*Standing
+	var search func(left []*Standing) bool
+	search = func(left []*Standing) bool {
+		if len(left) == 0 {
+			return true
+		}
+		if budget--; budget < 0 {
+			return false
+		}
+		p := left[0]
+		// The score group of p, its top half S1, p
+		// being the first, and bottom half S2.
+		group := 1
+		for group < len(left) && left[group].Points == p.Points {
+			group++
+		}
+		var order []int
+		half := group / 2
+		if half == 0 {
+			// p floats down, to the top of the next group
+			for i := 1; i < len(left); i++ {
+				order = append(order, i)
+			}
+		} else {
+			for i := half; i < group; i++ {
+				order = append(order, i)
+			}
+			for i := half - 1; i > 0; i-- {
+				order = append(order, i)
+			}
+			for i := group; i < len(left); i++ {
+				order = append(order, i)
+			}
+		}
+		for _, i := range order {
+			q := left[i]
+			if !mayMeet(p, q, relaxed) {
+				continue
+			}
+			rest := make([]*Standing, 0, len(left)-2)
+			rest = append(rest, left[1:i]...)
+			rest = append(rest, left[i+1:]...)
+			pairs = append(pairs, [2]*Standing{p, q})
+			if search(rest) {
+				return true
+			}
+			pairs = pairs[:len(pairs)-1]
+		}
+		return false
+	}
+	return pairs, search(ps)
+}
+
+// boardsOf numbers the boards of pairs, the bye last.
+func boardsOf(pairs [][2]*Standing, bye string) []Pairing {
+	var round []Pairing
+	for i, pair := range pairs {
+		round = append(round, allocate(pair[0], pair[1], i+1))
+	}
+	if bye != "" {
+		round = append(round, Pairing{Board: len(round) + 1, White: bye})
+	}
+	return round
+}
+
+// roundRobinRounds returns how many rounds everyone
+// playing everyone of n takes.
+func roundRobinRounds(n int) int {
+	if n%2 == 1 {
+		return n
+	}
+	return n - 1
+}
+
+// roundRobinPairings pairs round, from 1, of players
+// in seed order by the Berger tables: with the last
+// player p fixed, in round r the others i and j meet
+// when i+j is r modulo p, and the one who'd meet
+// themself meets p. An odd number have a bye as p.
+func roundRobinPairings(players []string, round int) []Pairing {
+	n := len(players)
+	if n%2 == 1 {
+		players = append(append([]string(nil), players...), "")
+		n++
+	}
+	fixed := n - 1
+	r := (round - 1) % fixed
+	var pairs []Pairing
+	var bye string
+	for i := 0; i < fixed; i++ {
+		j := ((r-i)%fixed + fixed) % fixed
+		switch {
+		case j == i:
+			// The fixed player alternates colours
+			white, black := players[i], players[fixed]
+			if r%2 == 1 {
+				white, black = black, white
+			}
+			switch {
+			case white == "":
+				bye = black
+			case black == "":
+				bye = white
+			default:
+				pairs = append(pairs, Pairing{White: white, Black: black})
+			}
+		case i < j:
+			// Odd gaps give the first white
+			if (j-i)%2 == 1 {
+				pairs = append(pairs, Pairing{White: players[i], Black: players[j]})
+			} else {
+				pairs = append(pairs, Pairing{White: players[j], Black: players[i]})
+			}
+		}
+	}
+	for i := range pairs {
+		pairs[i].Board = i + 1
+	}
+	if bye != "" {
+		pairs = append(pairs, Pairing{Board: len(pairs) + 1, White: bye})
+	}
+	return pairs
+}
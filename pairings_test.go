@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestColourPreference(t *testing.T) {
+	cases := []struct {
+		colours  string
+		colour   byte
+		strength int
+	}{
+		{"", 0, noPreference},
+		{"w", 'b', strongPreference},
+		{"b", 'w', strongPreference},
+		{"wb", 'w', mildPreference},
+		{"bw", 'b', mildPreference},
+		{"ww", 'b', absolutePreference},
+		{"wbb", 'w', absolutePreference},
+		{"wwbwb", 'b', strongPreference},
+		{"bwbb", 'w', absolutePreference},
+	}
+	for _, c := range cases {
+		colour, strength := colourPreference(c.colours)
+		if colour != c.colour || strength != c.strength {
+			t.Errorf("%q: %q %d, want %q %d", c.colours, colour, strength, c.colour, c.strength)
+		}
+	}
+}
+
+// checkRound checks everybody of players is in round
+// once, and returns its bye, if any.
+func checkRound(t *testing.T, name string, players []string, round []Pairing) string {
+	t.Helper()
+	seen := make(map[string]bool)
+	var bye string
+	for i, p := range round {
+		if p.Board != i+1 {
+			t.Errorf("%s: board %d numbered %d", name, i+1, p.Board)
+		}
+		for _, s := range []string{p.White, p.Black} {
+			if s == "" {
+				continue
+			}
+			if seen[s] {
+				t.Errorf("%s: %s paired twice", name, s)
+			}
+			seen[s] = true
+		}
+		if p.Black == "" {
+			if bye != "" || i != len(round)-1 {
+				t.Errorf("%s: bye %s isn't the only and last", name, p.White)
+			}
+			bye = p.White
+		}
+	}
+	if len(seen) != len(players) {
+		t.Errorf("%s: %d paired of %d", name, len(seen), len(players))
+	}
+	return bye
+}
+
+// Over the rounds of a round robin everyone meets once,
+// everyone has a bye once if the number is odd, and the
+// colours are as even as they can be.
+func TestRoundRobinPairings(t *testing.T) {
+	for n := 2; n <= 10; n++ {
+		players := make([]string, n)
+		for i := range players {
+			players[i] = fmt.Sprintf("p%d", i+1)
+		}
+		met := make(map[[2]string]int)
+		byes := make(map[string]int)
+		whites := make(map[string]int)
+		rounds := roundRobinRounds(n)
+		for r := 1; r <= rounds; r++ {
+			name := fmt.Sprintf("%d players round %d", n, r)
+			round := roundRobinPairings(players, r)
+			if bye := checkRound(t, name, players, round); bye != "" {
+				byes[bye]++
+			}
+			for _, p := range round {
+				if p.Black == "" {
+					continue
+				}
+				a, b := p.White, p.Black
+				if a > b {
+					a, b = b, a
+				}
+				met[[2]string{a, b}]++
+				whites[p.White]++
+			}
+		}
+		if want := n * (n - 1) / 2; len(met) != want {
+			t.Errorf("%d players: %d pairs met, want %d", n, len(met), want)
+		}
+		for pair, times := range met {
+			if times != 1 {
+				t.Errorf("%d players: %v met %d times", n, pair, times)
+			}
+		}
+		for _, p := range players {
+			if n%2 == 1 && byes[p] != 1 {
+				t.Errorf("%d players: %s had %d byes", n, p, byes[p])
+			}
+			if n%2 == 0 && byes[p] != 0 {
+				t.Errorf("%d players: %s had a bye", n, p)
+			}
+			black := n - 1 - whites[p]
+			if d := whites[p] - black; d > 1 || d < -1 {
+				t.Errorf("%d players: %s had %d whites, %d blacks", n, p, whites[p], black)
+			}
+		}
+	}
+}
+
+// The first round of a Swiss is the top half against
+// the bottom, the higher seed white on the odd boards.
+func TestSwissFirstRound(t *testing.T) {
+	var standings []Standing
+	for i := 1; i <= 7; i++ {
+		standings = append(standings, Standing{Name: fmt.Sprintf("p%d", i), Seed: i,
+			opponents: make(map[string]bool)})
+	}
+	got := swissPairings(standings)
+	want := []Pairing{
+		{Board: 1, White: "p1", Black: "p4"},
+		{Board: 2, White: "p5", Black: "p2"},
+		{Board: 3, White: "p3", Black: "p6"},
+		{Board: 4, White: "p7"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%v, want %v", got, want)
+	}
+}
+
+// Over a Swiss nobody meets twice, a bye only goes to
+// someone without one, and nobody gets a colour three
+// times running or two more of one than the other.
+func TestSwissPairings(t *testing.T) {
+	for _, n := range []int{6, 7, 8, 9} {
+		players := make([]string, n)
+		by := make(map[string]*Standing)
+		standings := make([]Standing, n)
+		for i := range players {
+			players[i] = fmt.Sprintf("p%d", i+1)
+			standings[i] = Standing{Name: players[i], Seed: i + 1,
+				opponents: make(map[string]bool)}
+		}
+		rounds := 5
+		if n < 8 {
+			rounds = n - 2
+		}
+		for r := 1; r <= rounds; r++ {
+			name := fmt.Sprintf("%d players round %d", n, r)
+			for i := range standings {
+				by[standings[i].Name] = &standings[i]
+			}
+			round := swissPairings(standings)
+			bye := checkRound(t, name, players, round)
+			if bye != "" {
+				if by[bye].bye {
+					t.Errorf("%s: second bye for %s", name, bye)
+				}
+				by[bye].bye = true
+				by[bye].Points++
+			}
+			for i, p := range round {
+				if p.Black == "" {
+					continue
+				}
+				w, b := by[p.White], by[p.Black]
+				if w.opponents[b.Name] {
+					t.Errorf("%s: %s and %s meet again", name, w.Name, b.Name)
+				}
+				w.opponents[b.Name], b.opponents[w.Name] = true, true
+				w.colours += "w"
+				b.colours += "b"
+				// Results which mix up the score groups
+				switch (i + r) % 3 {
+				case 0:
+					w.Points++
+				case 1:
+					b.Points++
+				default:
+					w.Points += 0.5
+					b.Points += 0.5
+				}
+			}
+		}
+		for _, s := range standings {
+			whites := 0
+			for _, c := range s.colours {
+				if c == 'w' {
+					whites++
+				}
+			}
+			if d := 2*whites - len(s.colours); d > 2 || d < -2 {
+				t.Errorf("%d players: %s played %s", n, s.Name, s.colours)
+			}
+			for i := 2; i < len(s.colours); i++ {
+				if s.colours[i] == s.colours[i-1] && s.colours[i] == s.colours[i-2] {
+					t.Errorf("%d players: %s played %s", n, s.Name, s.colours)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// perftCase is a reference position with its known
+// leaf counts, Nodes[d] for depth d.
+type perftCase struct {
+	Name  string
+	Fen   string
+	Nodes map[int]int
+}
+
+// perftSuite holds the standard reference positions
+// and Martin Sedlak's edge cases, castling, en passant
+// and promotions included. Ghess only promotes to
+// queens, so its underpromotion counts can't match.
+var perftSuite = []perftCase{
+	{"Start", startFen,
+		map[int]int{1: 20, 2: 400, 3: 8902, 4: 197281, 5: 4865609}},
+	{"Kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		map[int]int{1: 48, 2: 2039, 3: 97862, 4: 4085603}},
+	{"Position 3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		map[int]int{1: 14, 2: 191, 3: 2812, 4: 43238, 5: 674624}},
+	{"Position 4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		map[int]int{1: 6, 2: 264, 3: 9467, 4: 422333}},
+	{"Position 4 mirrored", "r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1",
+		map[int]int{1: 6, 2: 264, 3: 9467, 4: 422333}},
+	{"Position 5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+		map[int]int{1: 44, 2: 1486, 3: 62379, 4: 2103487}},
+	{"Position 6", "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+		map[int]int{1: 46, 2: 2079, 3: 89890, 4: 3894594}},
+	{"Illegal en passant 1", "3k4/3p4/8/K1P4r/8/8/8/8 b - - 0 1",
+		map[int]int{6: 1134888}},
+	{"Illegal en passant 2", "8/8/4k3/8/2p5/8/B2P2K1/8 w - - 0 1",
+		map[int]int{6: 1015133}},
+	{"En passant gives check", "8/8/1k6/2b5/2pP4/8/5K2/8 b - d3 0 1",
+		map[int]int{6: 1440467}},
+	{"Short castle gives check", "5k2/8/8/8/8/8/8/4K2R w K - 0 1",
+		map[int]int{6: 661072}},
+	{"Long castle gives check", "3k4/8/8/8/8/8/8/R3K3 w Q - 0 1",
+		map[int]int{6: 803711}},
+	{"Castling rights", "r3k2r/1b4bq/8/8/8/8/7B/R3K2R w KQkq - 0 1",
+		map[int]int{4: 1274206}},
+	{"Castling prevented", "r3k2r/8/3Q4/8/8/5q2/8/R3K2R b KQkq - 0 1",
+		map[int]int{4: 1720476}},
+	{"Promote out of check", "2K2r2/4P3/8/8/8/8/8/3k4 w - - 0 1",
+		map[int]int{6: 3821001}},
+	{"Discovered check", "8/8/1P2K3/8/2n5/1q6/8/5k2 b - - 0 1",
+		map[int]int{5: 1004658}},
+	{"Promote to give check", "4k3/1P6/8/8/8/8/K7/8 w - - 0 1",
+		map[int]int{6: 217342}},
+	{"Underpromote to give check", "8/P1k5/K7/8/8/8/8/8 w - - 0 1",
+		map[int]int{6: 92683}},
+	{"Self stalemate", "K1k5/8/P7/8/8/8/8/8 w - - 0 1",
+		map[int]int{6: 2217}},
+	{"Stalemate and checkmate 1", "8/k1P5/8/1K6/8/8/8/8 w - - 0 1",
+		map[int]int{7: 567584}},
+	{"Stalemate and checkmate 2", "8/8/2k5/5q2/5n2/8/5K2/8 b - - 0 1",
+		map[int]int{4: 23527}},
+}
+
+// ghessPerft counts the leaves of the move tree of b
+// to depth, trusting SearchValid and Move for legality.
+func ghessPerft(b *ghess.Board, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	nodes := 0
+	origs, dests := b.SearchValid()
+	for i := range origs {
+		next := ghess.CopyBoard(b)
+		if next.Move(origs[i], dests[i]) != nil {
+			continue
+		}
+		nodes += ghessPerft(next, depth-1)
+	}
+	return nodes
+}
+
+// ghessDivide returns the perft of every root move of
+// b, by UCI move.
+func ghessDivide(b *ghess.Board, depth int) map[string]int {
+	counts := make(map[string]int)
+	origs, dests := b.SearchValid()
+	for i := range origs {
+		next := ghess.CopyBoard(b)
+		if next.Move(origs[i], dests[i]) != nil {
+			continue
+		}
+		counts[uciMove(b, origs[i], dests[i])] += ghessPerft(next, depth-1)
+	}
+	return counts
+}
+
+// perftOf counts fen to depth with the generator gen,
+// "bitboard" or "ghess", per root move if div.
+func perftOf(fen string, depth int, gen string, div bool) (int, map[string]int, error) {
+	switch gen {
+	case "bitboard":
+		p, err := ParseFen(fen)
+		if err != nil {
+			return 0, nil, err
+		}
+		if div {
+			return 0, p.divide(depth), nil
+		}
+		return p.perft(depth), nil, nil
+	case "ghess":
+		game, err := loadFen(fen)
+		if err != nil {
+			return 0, nil, err
+		}
+		if div {
+			return 0, ghessDivide(&game, depth), nil
+		}
+		return ghessPerft(&game, depth), nil, nil
+	}
+	return 0, nil, errors.New("Unknown generator " + gen)
+}
+
+// nps returns the nodes a second of a count.
+func nps(nodes int, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(float64(nodes) / d.Seconds())
+}
+
+// PerftCommand runs "growser perft", counting the
+// move tree of a position or checking the suite.
+func PerftCommand(args []string) {
+	flags := flag.NewFlagSet("perft", flag.ExitOnError)
+	fen := flags.String("fen", startFen, "position to count")
+	depth := flags.Int("depth", 3, "plies to count")
+	div := flags.Bool("divide", false, "count every root move")
+	suite := flags.Bool("suite", false, "check the reference positions")
+	maxNodes := flags.Int("maxnodes", 1000000, "suite: skip counts above this")
+	gen := flags.String("gen", "bitboard", "move generator, bitboard or ghess")
+	flags.Parse(args)
+
+	if *suite {
+		if !perftCheck(*maxNodes, *gen) {
+			os.Exit(1)
+		}
+		return
+	}
+	start := time.Now()
+	total, counts, err := perftOf(*fen, *depth, *gen, *div)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *div {
+		moves := make([]string, 0, len(counts))
+		for mv := range counts {
+			moves = append(moves, mv)
+		}
+		sort.Strings(moves)
+		for _, mv := range moves {
+			fmt.Printf("%s: %d\n", mv, counts[mv])
+			total += counts[mv]
+		}
+		fmt.Println("Moves:", len(moves))
+	}
+	took := time.Since(start)
+	fmt.Printf("Nodes: %d\nTime: %s\nNps: %d\n", total,
+		took.Round(time.Millisecond), nps(total, took))
+}
+
+// perftCheck counts every suite position to every
+// known depth with at most maxNodes leaves, and
+// reports the mismatches with the generator gen.
+// It returns true if all pass.
+func perftCheck(maxNodes int, gen string) bool {
+	var passed, failed, skipped, total int
+	began := time.Now()
+	for _, c := range perftSuite {
+		depths := make([]int, 0, len(c.Nodes))
+		for d := range c.Nodes {
+			depths = append(depths, d)
+		}
+		sort.Ints(depths)
+		for _, d := range depths {
+			want := c.Nodes[d]
+			if want > maxNodes {
+				fmt.Printf("skip %s depth %d: %d nodes\n", c.Name, d, want)
+				skipped++
+				continue
+			}
+			start := time.Now()
+			got, _, err := perftOf(c.Fen, d, gen, false)
+			took := time.Since(start)
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", c.Name, err)
+				failed++
+				break
+			}
+			total += got
+			status := "ok  "
+			if got != want {
+				status = "FAIL"
+				failed++
+			} else {
+				passed++
+			}
+			fmt.Printf("%s %s depth %d: %d, want %d (%s, %d nps)\n",
+				status, c.Name, d, got, want,
+				took.Round(time.Millisecond), nps(got, took))
+		}
+	}
+	took := time.Since(began)
+	fmt.Printf("Passed %d, failed %d, skipped %d\n", passed, failed, skipped)
+	fmt.Printf("Nodes: %d\nTime: %s\nNps: %d\n", total,
+		took.Round(time.Millisecond), nps(total, took))
+	return failed == 0
+}
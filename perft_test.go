@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// perftTestNodes is the most leaves a perft test counts,
+// deeper counts are for growser perft -suite.
+const perftTestNodes = 500000
+
+func TestPerftSuite(t *testing.T) {
+	for _, c := range perftSuite {
+		for d, want := range c.Nodes {
+			if want > perftTestNodes {
+				continue
+			}
+			got, _, err := perftOf(c.Fen, d, "bitboard", false)
+			if err != nil {
+				t.Fatalf("%s: %s", c.Name, err)
+			}
+			if got != want {
+				t.Errorf("%s depth %d: %d, want %d", c.Name, d, got, want)
+			}
+		}
+	}
+}
+
+// ghess is the generator the bitboards replaced. It
+// misses moves in the other positions, see growser perft.
+func TestPerftGhess(t *testing.T) {
+	c := perftSuite[0]
+	for d := 1; d <= 3; d++ {
+		got, _, err := perftOf(c.Fen, d, "ghess", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.Nodes[d] {
+			t.Errorf("%s depth %d: %d, want %d", c.Name, d, got, c.Nodes[d])
+		}
+	}
+}
+
+func TestPerftDivide(t *testing.T) {
+	_, div, err := perftOf(startFen, 3, "bitboard", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range div {
+		total += n
+	}
+	if len(div) != 20 || total != 8902 || div["e2e4"] != 600 {
+		t.Errorf("got %d moves, %d leaves, e2e4 %d; want 20, 8902, 600",
+			len(div), total, div["e2e4"])
+	}
+}
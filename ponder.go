@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// ponder is a search of the position a game reaches
+// if the human plays the predicted move.
+type ponder struct {
+	position string // ghess's FEN of the position
+	stopCh   chan struct{}
+	done     chan struct{} // closed with res set
+	res      Result
+	err      error
+}
+
+var (
+	// pondering is whether the AI thinks on the human's time.
+	pondering bool
+	// ponderTime is the longest the AI thinks on the human's time.
+	ponderTime = 30 * time.Second
+
+	pondersMu sync.Mutex
+	ponders   = make(map[string]*ponder) // by game id
+)
+
+// startPonder searches game id's position after the
+// human's reply in pv, the AI's principal variation.
+// It only ponders with a thread idle in searchPool, and
+// with growser's search, which stops when told.
+func startPonder(id string, g *ghess.Board, pv [][2]int, diff int) {
+	if !pondering || len(pv) < 2 {
+		return
+	}
+	if _, ok := engineFor(diff).(NegamaxEngine); !ok {
+		return
+	}
+	next := ghess.CopyBoard(g)
+	if err := next.Move(pv[1][0], pv[1][1]); err != nil || next.Checkmate {
+		return
+	}
+	if searchPool.acquire(1) < 1 {
+		return
+	}
+	p := &ponder{
+		position: next.Position(),
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	l := limitsFor(diff)
+	l.MoveTime = ponderTime
+	l.Threads = 1
+	l.Stop = p.stopCh
+	go func() {
+		p.res, p.err = searchMove(next, diff, l)
+		searchPool.release(1)
+		close(p.done)
+		// The human may never answer
+		time.AfterFunc(ponderTime, func() { forgetPonder(id, p) })
+	}()
+	pondersMu.Lock()
+	old := ponders[id]
+	ponders[id] = p
+	pondersMu.Unlock()
+	if old != nil {
+		old.cancel()
+	}
+}
+
+// takePonder ends the ponder of game id. If it was of
+// g's position, the human played the predicted move,
+// and it gets engineMoveTime more to finish its search.
+func takePonder(id string, g *ghess.Board) (Result, bool) {
+	pondersMu.Lock()
+	p := ponders[id]
+	delete(ponders, id)
+	pondersMu.Unlock()
+	if p == nil {
+		return Result{}, false
+	}
+	if p.position != g.Position() {
+		p.cancel()
+		return Result{}, false
+	}
+	select {
+	case <-p.done:
+	case <-time.After(engineMoveTime):
+		p.cancel()
+	}
+	if p.err != nil || p.res.Orig == 0 {
+		return Result{}, false
+	}
+	return p.res, true
+}
+
+// stopPonder forgets the ponder of game id, when the
+// game is over or its last player has left.
+func stopPonder(id string) {
+	pondersMu.Lock()
+	p := ponders[id]
+	delete(ponders, id)
+	pondersMu.Unlock()
+	if p != nil {
+		p.cancel()
+	}
+}
+
+// forgetPonder forgets p if it's still the ponder of
+// game id.
+func forgetPonder(id string, p *ponder) {
+	pondersMu.Lock()
+	if ponders[id] == p {
+		delete(ponders, id)
+	}
+	pondersMu.Unlock()
+}
+
+// stopPonders forgets every ponder, giving their threads
+// back to searchPool, and returns how many there were.
+func stopPonders() int {
+	pondersMu.Lock()
+	all := ponders
+	ponders = make(map[string]*ponder)
+	pondersMu.Unlock()
+	for _, p := range all {
+		p.cancel()
+	}
+	return len(all)
+}
+
+// cancel stops the search and waits for it, once the
+// ponder is out of ponders.
+func (p *ponder) cancel() {
+	close(p.stopCh)
+	<-p.done
+}
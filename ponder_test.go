@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// ponderGame returns the start after e4, and the
+// AI's line e4 e5.
+func ponderGame() (ghess.Board, [][2]int) {
+	m := ghess.PgnToCoordMap
+	game := ghess.NewBoard()
+	game.Move(m["e2"], m["e4"])
+	return game, [][2]int{{m["e2"], m["e4"]}, {m["e7"], m["e5"]}}
+}
+
+func TestPonder(t *testing.T) {
+	defer func(saved *threadPool) { searchPool = saved }(searchPool)
+	defer func(saved bool) { pondering = saved }(pondering)
+	searchPool = newThreadPool(2)
+	pondering = true
+	m := ghess.PgnToCoordMap
+
+	// The human plays the predicted move
+	game, pv := ponderGame()
+	startPonder("hit", &game, pv, 3)
+	game.Move(m["e7"], m["e5"])
+	res, ok := takePonder("hit", &game)
+	if !ok || res.Orig == 0 {
+		t.Fatal("no move from the ponder")
+	}
+	if p := boardOf(&game)[res.Orig]; p < 'A' || p > 'Z' {
+		t.Errorf("ponder moved %c, not white's", p)
+	}
+
+	// The human plays something else
+	game, pv = ponderGame()
+	startPonder("miss", &game, pv, 3)
+	game.Move(m["d7"], m["d5"])
+	if _, ok := takePonder("miss", &game); ok {
+		t.Error("ponder of another position taken")
+	}
+	if _, ok := takePonder("none", &game); ok {
+		t.Error("ponder of no game taken")
+	}
+
+	game, pv = ponderGame()
+	startPonder("a", &game, pv, 3)
+	startPonder("b", &game, pv, 3)
+	stopPonder("a")
+	if n := stopPonders(); n != 1 {
+		t.Errorf("%d ponders stopped, want 1", n)
+	}
+	if n := searchPool.acquire(2); n != 2 {
+		t.Errorf("%d threads back of 2", n)
+	}
+}
+
+func TestPonderOff(t *testing.T) {
+	defer func(saved bool) { pondering = saved }(pondering)
+	pondering = false
+	game, pv := ponderGame()
+	startPonder("off", &game, pv, 3)
+	if n := stopPonders(); n != 0 {
+		t.Errorf("%d ponders while off", n)
+	}
+}
+
+// pondered is whether game id has a ponder.
+func pondered(id string) bool {
+	pondersMu.Lock()
+	defer pondersMu.Unlock()
+	return ponders[id] != nil
+}
+
+// A ponder nobody takes is forgotten after ponderTime,
+// or when the last player of its game leaves.
+func TestPonderForgotten(t *testing.T) {
+	defer func(saved *threadPool) { searchPool = saved }(searchPool)
+	defer func(saved bool) { pondering = saved }(pondering)
+	defer func(saved time.Duration) { ponderTime = saved }(ponderTime)
+	searchPool = newThreadPool(2)
+	pondering = true
+	ponderTime = 50 * time.Millisecond
+
+	game, pv := ponderGame()
+	startPonder("idle", &game, pv, 3)
+	time.Sleep(10 * ponderTime)
+	if pondered("idle") {
+		t.Error("an unanswered ponder is kept")
+	}
+
+	ponderTime = time.Minute
+	hub := newHub()
+	go hub.run()
+	a := &Client{hub: hub, send: make(chan []byte), game: "left"}
+	b := &Client{hub: hub, send: make(chan []byte), game: "left"}
+	hub.register <- a
+	hub.register <- b
+	startPonder("left", &game, pv, 3)
+	hub.unregister <- a
+	hub.register <- &Client{hub: hub, send: make(chan []byte)}
+	if !pondered("left") {
+		t.Error("ponder forgotten with a player still there")
+	}
+	hub.unregister <- b
+	hub.register <- &Client{hub: hub, send: make(chan []byte)}
+	if pondered("left") {
+		t.Error("ponder kept after its players left")
+	}
+	stopPonders()
+}
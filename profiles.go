@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// seatGames indexes the records by seat: the lower
+	// cased seat, a zero byte, the time the game was made
+	// then its id, to a GameSummary. Newest last.
+	seatGames = []byte("seatgames")
+	// seatStats holds the SeatStats of every seat.
+	seatStats = []byte("seatstats")
+)
+
+// historyPage is how many games a history page lists.
+const historyPage = 20
+
+// recentGames is how many games a profile lists.
+const recentGames = 10
+
+// GameSummary is a game as one of its players sees it.
+type GameSummary struct {
+	Id       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Created  time.Time `json:"created"`
+	Colour   string    `json:"colour"`   // "w" or "b"
+	Opponent string    `json:"opponent"` // a seat, see Record, guests' redacted
+	Level    int       `json:"level,omitempty"`
+	Result   string    `json:"result"`  // the Record's
+	Outcome  string    `json:"outcome"` // "win", "draw", "loss" or empty
+	Opening  string    `json:"opening"`
+	Plies    int       `json:"plies"`
+	Rated    bool      `json:"rated"`
+	Category string    `json:"category,omitempty"`
+}
+
+// Tally counts finished games.
+type Tally struct {
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+}
+
+// SeatStats are a seat's games, and its results by
+// colour and by opponent.
+type SeatStats struct {
+	Games int   `json:"games"`
+	White Tally `json:"white"`
+	Black Tally `json:"black"`
+	Ai    Tally `json:"ai"`
+	Human Tally `json:"human"`
+}
+
+// add counts an outcome, n being 1 or -1.
+func (t *Tally) add(outcome string, n int) {
+	switch outcome {
+	case "win":
+		t.Wins += n
+	case "draw":
+		t.Draws += n
+	case "loss":
+		t.Losses += n
+	}
+}
+
+// seatPrefix starts the seatGames keys of a seat.
+func seatPrefix(seat string) []byte {
+	return []byte(strings.ToLower(seat) + "\x00")
+}
+
+// seatGameKey is the seatGames key of rec for seat.
+func seatGameKey(seat string, rec Record) []byte {
+	k := seatPrefix(seat)
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(rec.Created.UnixNano()))
+	k = append(k, t[:]...)
+	return append(k, rec.Id...)
+}
+
+// summaryOf returns rec as the player of colour sees it.
+func summaryOf(rec Record, colour string) GameSummary {
+	s := GameSummary{Id: rec.Id, Kind: rec.Kind, Created: rec.Created,
+		Colour: colour, Opponent: rec.Black, Level: rec.Level,
+		Result: rec.Result, Opening: openingName(rec.Start, rec.Moves),
+		Plies: len(rec.Moves), Rated: rec.Rated, Category: rec.Category}
+	if colour == "b" {
+		s.Opponent = rec.White
+	}
+	if score, ok := resultScore(rec.Result); ok {
+		if colour == "b" {
+			score = 1 - score
+		}
+		s.Outcome = outcomeOf(score)
+	}
+	return s
+}
+
+// outcomeOf returns "win", "draw" or "loss" for a score.
+func outcomeOf(score float64) string {
+	switch score {
+	case 1:
+		return "win"
+	case 0:
+		return "loss"
+	}
+	return "draw"
+}
+
+// getSeatStats reads the stats of a seat.
+func getSeatStats(tx *bolt.Tx, seat string) SeatStats {
+	var st SeatStats
+	if bucket := tx.Bucket(seatStats); bucket != nil {
+		if val := bucket.Get([]byte(strings.ToLower(seat))); val != nil {
+			json.Unmarshal(val, &st)
+		}
+	}
+	return st
+}
+
+// countGame adds a game's summary to the stats of its
+// seat, or takes it away with n -1.
+func countGame(tx *bolt.Tx, seat string, s GameSummary, newGame bool, n int) error {
+	bucket, err := tx.CreateBucketIfNotExists(seatStats)
+	if err != nil {
+		return err
+	}
+	st := getSeatStats(tx, seat)
+	if newGame {
+		st.Games += n
+	}
+	if s.Colour == "w" {
+		st.White.add(s.Outcome, n)
+	} else {
+		st.Black.add(s.Outcome, n)
+	}
+	if s.Opponent == aiSeat {
+		st.Ai.add(s.Outcome, n)
+		if s.Outcome != "" {
+			err = levelLeader(tx, seat, s.Level, s.Outcome, n)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		st.Human.add(s.Outcome, n)
+	}
+	val, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(strings.ToLower(seat)), val)
+}
+
+// indexRecord keeps the seatGames and seatStats of rec,
+// which was old, up to date. Every seat but the AI's
+// is indexed, so a guest's games are found when they
+// register.
+func indexRecord(tx *bolt.Tx, old, rec Record) error {
+	bucket, err := tx.CreateBucketIfNotExists(seatGames)
+	if err != nil {
+		return err
+	}
+	seats := [2]struct{ old, new, colour string }{
+		{old.White, rec.White, "w"}, {old.Black, rec.Black, "b"}}
+	for _, seat := range seats {
+		// Uncount a game whose key changes, from the
+		// summary it was counted with
+		oldKey := seatGameKey(seat.old, old)
+		if seat.old != seat.new || !old.Created.Equal(rec.Created) {
+			if val := bucket.Get(oldKey); val != nil {
+				var s GameSummary
+				json.Unmarshal(val, &s)
+				err = bucket.Delete(oldKey)
+				if err != nil {
+					return err
+				}
+				err = countGame(tx, seat.old, s, true, -1)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if seat.new == "" || seat.new == aiSeat {
+			continue
+		}
+		s := summaryOf(rec, seat.colour)
+		val, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		key := seatGameKey(seat.new, rec)
+		isNew := bucket.Get(key) == nil
+		err = bucket.Put(key, val)
+		if err != nil {
+			return err
+		}
+		// Count the game once, and its result once it's over
+		if isNew {
+			err = countGame(tx, seat.new, s, true, 1)
+		} else if old.Result == "" && rec.Result != "" {
+			err = countGame(tx, seat.new, s, false, 1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexRecords indexes the records of a database
+// from before seatGames, once.
+func reindexRecords() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(seatGames) != nil {
+			return nil
+		}
+		_, err := tx.CreateBucket(seatGames)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(records)
+		if bucket == nil {
+			return nil
+		}
+		var recs []Record
+		err = bucket.ForEach(func(k, v []byte) error {
+			var rec Record
+			if json.Unmarshal(v, &rec) == nil {
+				recs = append(recs, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			// AI games are keyed by the unix time they were made
+			if secs, err := strconv.ParseInt(rec.Id, 10, 64); err == nil && rec.Created.IsZero() {
+				rec.Created = time.Unix(secs, 0)
+			}
+			err = putRecord(tx, rec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seatHistory returns a page of a seat's games, newest
+// first, by walking back from the end of its keys.
+func seatHistory(tx *bolt.Tx, seat string, page, size int) []GameSummary {
+	games := make([]GameSummary, 0, size)
+	bucket := tx.Bucket(seatGames)
+	if bucket == nil {
+		return games
+	}
+	prefix := seatPrefix(seat)
+	end := append(append([]byte(nil), prefix[:len(prefix)-1]...), 1)
+	c := bucket.Cursor()
+	k, v := c.Seek(end)
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	skip := page * size
+	for ; k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Prev() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		var s GameSummary
+		if json.Unmarshal(v, &s) == nil {
+			// A guest's id is their cookie, it mustn't be shown
+			if strings.HasPrefix(s.Opponent, guestPrefix) {
+				s.Opponent = guestPrefix
+			}
+			games = append(games, s)
+		}
+		if len(games) == size {
+			break
+		}
+	}
+	return games
+}
+
+// seatGameIds returns the ids of a seat's games.
+func seatGameIds(tx *bolt.Tx, seat string) []string {
+	var ids []string
+	bucket := tx.Bucket(seatGames)
+	if bucket == nil {
+		return ids
+	}
+	prefix := seatPrefix(seat)
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+		ids = append(ids, string(k[len(prefix)+8:]))
+	}
+	return ids
+}
+
+// UserPage is what a user's page shows, and its JSON.
+type UserPage struct {
+	Name    string            `json:"name"`
+	Joined  time.Time         `json:"joined"`
+	Ratings map[string]Rating `json:"ratings"`
+	Stats   SeatStats         `json:"stats"`
+	Recent  []GameSummary     `json:"recent"`
+	// Strength is how they do against each level of
+	// the AI, and the level Auto plays them.
+	Strength Player `json:"strength"`
+}
+
+// HistoryPage is a page of a user's games, and its JSON.
+type HistoryPage struct {
+	Name  string        `json:"name"`
+	Page  int           `json:"page"`
+	Pages int           `json:"pages"`
+	Games []GameSummary `json:"games"`
+}
+
+// userProfile reads the profile of name, nil for
+// nobody.
+func userProfile(name string) *UserPage {
+	var p *UserPage
+	err := db.View(func(tx *bolt.Tx) error {
+		u := getUser(tx, name)
+		if u == nil {
+			return nil
+		}
+		p = &UserPage{Name: u.Name, Joined: u.Created,
+			Ratings:  make(map[string]Rating),
+			Stats:    getSeatStats(tx, u.Name),
+			Recent:   seatHistory(tx, u.Name, 0, recentGames),
+			Strength: getPlayer(tx, u.Name)}
+		now := time.Now()
+		for _, cat := range categories {
+			if bucket := tx.Bucket(ratings); bucket != nil {
+				if val := bucket.Get(ratingKey(u.Name, cat)); val != nil {
+					var rt Rating
+					if json.Unmarshal(val, &rt) == nil {
+						p.Ratings[cat] = rt.idle(now)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return p
+}
+
+// userHistory reads a page of name's games, nil for
+// nobody.
+func userHistory(name string, page int) *HistoryPage {
+	var h *HistoryPage
+	err := db.View(func(tx *bolt.Tx) error {
+		u := getUser(tx, name)
+		if u == nil {
+			return nil
+		}
+		games := getSeatStats(tx, u.Name).Games
+		h = &HistoryPage{Name: u.Name, Page: page,
+			Pages: (games + historyPage - 1) / historyPage,
+			Games: seatHistory(tx, u.Name, page, historyPage)}
+		if h.Pages < 1 {
+			h.Pages = 1
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return h
+}
+
+// pageOf returns the page asked for by r, from 0.
+func pageOf(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		return 0
+	}
+	return page
+}
+
+// writeJson writes v, or a 404 JSON error if it's nil.
+func writeJson(w http.ResponseWriter, v interface{}, found bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such user"}`))
+		return
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write(js)
+}
+
+// profileFuncs help the profile templates show games.
+var profileFuncs = template.FuncMap{
+	"opponent": func(s GameSummary) string {
+		switch {
+		case s.Opponent == aiSeat && s.Level > 0:
+			return "Computer, " + levelName(s.Level)
+		case s.Opponent == aiSeat:
+			return "Computer"
+		case s.Opponent == "" || strings.HasPrefix(s.Opponent, guestPrefix):
+			return "Guest"
+		}
+		return s.Opponent
+	},
+	"isUser": func(seat string) bool {
+		return seat != "" && seat != aiSeat && !strings.HasPrefix(seat, guestPrefix)
+	},
+	"link": func(s GameSummary) string {
+		if s.Kind == "challenge" {
+			return "/challenge/" + s.Id
+		}
+		return "/view/" + s.Id
+	},
+	"colour": func(c string) string {
+		if c == "b" {
+			return "Black"
+		}
+		return "White"
+	},
+	"level": levelName,
+	"round": func(f float64) int { return int(f + 0.5) },
+	"add":   func(a, b int) int { return a + b },
+	"date":  func(t time.Time) string { return t.Format("2 Jan 2006") },
+}
+
+// profileTemplate parses a profile template.
+func profileTemplate(name string) (*template.Template, error) {
+	return template.New(name).Funcs(profileFuncs).ParseFiles("templates/" + name)
+}
+
+// A user's profile page
+func ProfileUser(w http.ResponseWriter,
+	r *http.Request) {
+	p := userProfile(mux.Vars(r)["name"])
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	t, err := profileTemplate("profile.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	err = t.Execute(w, p)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// JSON profile of a user
+func ProfileJson(w http.ResponseWriter,
+	r *http.Request) {
+	p := userProfile(mux.Vars(r)["name"])
+	writeJson(w, p, p != nil)
+}
+
+// A page of a user's games, ?page= from 0
+func HistoryUser(w http.ResponseWriter,
+	r *http.Request) {
+	h := userHistory(mux.Vars(r)["name"], pageOf(r))
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	t, err := profileTemplate("history.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	err = t.Execute(w, h)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// JSON page of a user's games, ?page= from 0
+func HistoryJson(w http.ResponseWriter,
+	r *http.Request) {
+	h := userHistory(mux.Vars(r)["name"], pageOf(r))
+	writeJson(w, h, h != nil)
+}
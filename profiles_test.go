@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestUserProfile(t *testing.T) {
+	openTestDb(t)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := createUser(name, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	put := func(rec Record) {
+		t.Helper()
+		err := db.Update(func(tx *bolt.Tx) error { return putRecord(tx, rec) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(Record{Id: "1", Kind: "challenge", Start: startFen, Created: start,
+		White: "alice", Black: "bob", Result: "1-0"})
+	put(Record{Id: "2", Kind: "ai", Start: startFen, Created: start.Add(time.Minute),
+		White: aiSeat, Black: "alice", Result: "1-0", Level: 3})
+	draw := Record{Id: "3", Kind: "challenge", Start: startFen, Created: start.Add(2 * time.Minute),
+		White: "alice", Black: guestPrefix + "abc"}
+	put(draw)
+	// Its result is counted when it comes
+	draw.Result = "1/2-1/2"
+	put(draw)
+	// A guest's game which alice takes over
+	put(Record{Id: "4", Kind: "challenge", Start: startFen, Created: start.Add(3 * time.Minute),
+		White: guestPrefix + "xyz", Black: "bob"})
+	put(Record{Id: "4", Kind: "challenge", Start: startFen,
+		White: "alice", Black: "bob"})
+	// Lost as black to level 3
+	finishGame("alice", 3, "b", "1-0")
+
+	p := userProfile("ALICE")
+	if p == nil {
+		t.Fatal("no profile")
+	}
+	if res := p.Strength.Results[3]; res == nil || res.Losses != 1 || p.Strength.Level != 2 {
+		t.Errorf("strength %+v, want a loss to level 3 and Auto at 2", p.Strength)
+	}
+	tmpl, err := profileTemplate("profile.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, p); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.String(), "Auto plays "+levelName(2)) {
+		t.Errorf("the page doesn't show the level Auto plays")
+	}
+	want := SeatStats{Games: 4,
+		White: Tally{Wins: 1, Draws: 1},
+		Black: Tally{Losses: 1},
+		Ai:    Tally{Losses: 1},
+		Human: Tally{Wins: 1, Draws: 1}}
+	if p.Stats != want {
+		t.Errorf("stats %+v, want %+v", p.Stats, want)
+	}
+	var ids, outcomes []string
+	for _, s := range p.Recent {
+		ids = append(ids, s.Id)
+		outcomes = append(outcomes, s.Outcome)
+	}
+	if want := []string{"4", "3", "2", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("recent %v, want %v", ids, want)
+	}
+	if want := []string{"", "draw", "loss", "win"}; !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("outcomes %v, want %v", outcomes, want)
+	}
+	if h := userHistory("alice", 0); h == nil || len(h.Games) != 4 {
+		t.Errorf("history %+v", h)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if games := seatHistory(tx, guestPrefix+"xyz", 0, historyPage); len(games) != 0 {
+			t.Errorf("the guest still has %d games", len(games))
+		}
+		if st := getSeatStats(tx, guestPrefix+"xyz"); st.Games != 0 {
+			t.Errorf("the guest still counts %d games", st.Games)
+		}
+		return nil
+	})
+	if userProfile("nobody") != nil {
+		t.Error("profile of nobody")
+	}
+}
+
+func TestGuestOpponentHidden(t *testing.T) {
+	openTestDb(t)
+	if _, err := createUser("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, Record{Id: "1", Kind: "challenge", Start: startFen,
+			White: "alice", Black: guestPrefix + "0123abcd", Result: "1-0"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := userProfile("alice")
+	h := userHistory("alice", 0)
+	if p == nil || h == nil || len(p.Recent) != 1 || len(h.Games) != 1 {
+		t.Fatalf("profile %+v, history %+v", p, h)
+	}
+	for _, s := range []GameSummary{p.Recent[0], h.Games[0]} {
+		if strings.Contains(s.Opponent, "0123abcd") {
+			t.Errorf("opponent %q shows the guest's id", s.Opponent)
+		}
+		if s.Outcome != "win" {
+			t.Errorf("outcome %q, want win", s.Outcome)
+		}
+	}
+	if p.Stats.Human.Wins != 1 {
+		t.Errorf("stats %+v, want a win against a human", p.Stats)
+	}
+}
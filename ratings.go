@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ratings holds a Rating for each user and category,
+	// by ratingKey.
+	ratings = []byte("ratings")
+	// ratingHistory holds a RatingPoint after every rated
+	// game, by ratingKey then the time, for graphs.
+	ratingHistory = []byte("ratinghistory")
+)
+
+// categories are the time controls rated separately. The
+// site has no clocks, so a game's category is what its
+// players agreed on when it was made.
+var categories = []string{"bullet", "blitz", "rapid", "classical", "correspondence"}
+
+// defaultCategory is the category of untimed games.
+const defaultCategory = "correspondence"
+
+// Glicko-2, see Glickman's "Example of the Glicko-2
+// system". Every rated game is a rating period of its
+// own, and the deviation grows again while a player
+// doesn't play.
+const (
+	defaultRating     = 1500
+	defaultDeviation  = 350
+	defaultVolatility = 0.06
+	glickoScale       = 173.7178
+	glickoTau         = 0.5 // how fast volatility changes
+	glickoEpsilon     = 0.000001
+	// ratingPeriod is the idle time over which the
+	// deviation grows by a period's volatility.
+	ratingPeriod = 7 * 24 * time.Hour
+	// aiDeviation is how sure we are of a level's rating.
+	aiDeviation = 60
+)
+
+// Rating is a player's Glicko-2 rating in a category.
+type Rating struct {
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Games      int       `json:"games"`
+	Updated    time.Time `json:"updated"`
+}
+
+// RatingPoint is a player's rating after a game.
+type RatingPoint struct {
+	Time      time.Time `json:"time"`
+	Rating    float64   `json:"rating"`
+	Deviation float64   `json:"deviation"`
+	Game      string    `json:"game"`
+}
+
+// newRating is the rating of someone who hasn't played.
+func newRating() Rating {
+	return Rating{Rating: defaultRating, Deviation: defaultDeviation,
+		Volatility: defaultVolatility}
+}
+
+// validCategory returns true if c is one of categories.
+func validCategory(c string) bool {
+	for _, cat := range categories {
+		if c == cat {
+			return true
+		}
+	}
+	return false
+}
+
+// ratingKey is the key of a user's rating in category,
+// the lower cased name then the category.
+func ratingKey(name, category string) []byte {
+	return []byte(strings.ToLower(name) + "\x00" + category + "\x00")
+}
+
+// getRating reads a user's rating in category.
+func getRating(tx *bolt.Tx, name, category string) Rating {
+	rt := newRating()
+	bucket := tx.Bucket(ratings)
+	if bucket == nil {
+		return rt
+	}
+	if val := bucket.Get(ratingKey(name, category)); val != nil {
+		json.Unmarshal(val, &rt)
+	}
+	return rt
+}
+
+// putRating writes a user's rating and its point in
+// the history.
+func putRating(tx *bolt.Tx, name, category, game string, rt Rating) error {
+	bucket, err := tx.CreateBucketIfNotExists(ratings)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	key := ratingKey(name, category)
+	err = bucket.Put(key, val)
+	if err != nil {
+		return err
+	}
+	history, err := tx.CreateBucketIfNotExists(ratingHistory)
+	if err != nil {
+		return err
+	}
+	val, err = json.Marshal(RatingPoint{Time: rt.Updated, Rating: rt.Rating,
+		Deviation: rt.Deviation, Game: game})
+	if err != nil {
+		return err
+	}
+	k := make([]byte, len(key)+8)
+	copy(k, key)
+	binary.BigEndian.PutUint64(k[len(key):], uint64(rt.Updated.UnixNano()))
+	return history.Put(k, val)
+}
+
+// idle grows the deviation of rt for the rating
+// periods since its last game, up to a newcomer's.
+func (rt Rating) idle(now time.Time) Rating {
+	if rt.Updated.IsZero() {
+		return rt
+	}
+	periods := float64(now.Sub(rt.Updated) / ratingPeriod)
+	phi := rt.Deviation / glickoScale
+	phi = math.Sqrt(phi*phi + periods*rt.Volatility*rt.Volatility)
+	rt.Deviation = math.Min(phi*glickoScale, defaultDeviation)
+	return rt
+}
+
+// glickoG weighs a result by the opponent's deviation.
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// glickoGame is a game of a rating period, score
+// being 1, 0.5 or 0 against opp.
+type glickoGame struct {
+	opp   Rating
+	score float64
+}
+
+// update returns rt after a game against opp, score
+// being 1, 0.5 or 0.
+func (rt Rating) update(opp Rating, score float64) Rating {
+	return rt.period([]glickoGame{{opp, score}})
+}
+
+// period returns rt after the games of a rating period.
+func (rt Rating) period(games []glickoGame) Rating {
+	mu := (rt.Rating - defaultRating) / glickoScale
+	phi := rt.Deviation / glickoScale
+	var vInv, sum float64
+	for _, gm := range games {
+		muJ := (gm.opp.Rating - defaultRating) / glickoScale
+		g := glickoG(gm.opp.Deviation / glickoScale)
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInv += g * g * e * (1 - e)
+		sum += g * (gm.score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// The new volatility, by the Illinois algorithm
+	sigma := rt.Volatility
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigma = math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+	return Rating{
+		Rating:     mu*glickoScale + defaultRating,
+		Deviation:  phi * glickoScale,
+		Volatility: sigma,
+		Games:      rt.Games + len(games),
+	}
+}
+
+// ratedSeat returns the user name of a seat, if it's
+// a registered user who may be rated.
+func ratedSeat(tx *bolt.Tx, seat string) (string, bool) {
+	if seat == "" || seat == aiSeat || strings.HasPrefix(seat, guestPrefix) {
+		return "", false
+	}
+	return seat, getUser(tx, seat) != nil
+}
+
+// rateGame updates the ratings of the players of a
+// finished rated game. An AI game rates the user
+// against the rating of the level it was played at.
+func rateGame(rec Record) error {
+	score, ok := resultScore(rec.Result)
+	if !rec.Rated || !ok {
+		return nil
+	}
+	category := rec.Category
+	if !validCategory(category) {
+		category = defaultCategory
+	}
+	now := time.Now()
+	return db.Update(func(tx *bolt.Tx) error {
+		white, okW := ratedSeat(tx, rec.White)
+		black, okB := ratedSeat(tx, rec.Black)
+		switch {
+		case rec.Kind == "ai":
+			h, ok := levels[rec.Level]
+			if !ok {
+				return nil
+			}
+			level := Rating{Rating: float64(h.Rating), Deviation: aiDeviation,
+				Volatility: defaultVolatility}
+			name := white
+			if rec.White == aiSeat {
+				name, okW, score = black, okB, 1-score
+			}
+			if !okW {
+				return nil
+			}
+			rt := getRating(tx, name, category).idle(now).update(level, score)
+			rt.Updated = now
+			err := putRating(tx, name, category, rec.Id, rt)
+			if err != nil {
+				return err
+			}
+			return rateLeader(tx, name, category, rt, outcomeOf(score))
+		case okW && okB && !strings.EqualFold(white, black):
+			w := getRating(tx, white, category).idle(now)
+			b := getRating(tx, black, category).idle(now)
+			newW, newB := w.update(b, score), b.update(w, 1-score)
+			newW.Updated, newB.Updated = now, now
+			err := putRating(tx, white, category, rec.Id, newW)
+			if err != nil {
+				return err
+			}
+			err = putRating(tx, black, category, rec.Id, newB)
+			if err != nil {
+				return err
+			}
+			err = rateLeader(tx, white, category, newW, outcomeOf(score))
+			if err != nil {
+				return err
+			}
+			return rateLeader(tx, black, category, newB, outcomeOf(1-score))
+		}
+		return nil
+	})
+}
+
+// UserRatings are a user's ratings by category, with
+// the deviation grown for the time since they played.
+type UserRatings struct {
+	Name    string            `json:"name"`
+	Ratings map[string]Rating `json:"ratings"`
+}
+
+// JSON ratings of a user in every category they played
+func RatingsUser(w http.ResponseWriter,
+	r *http.Request) {
+	name := mux.Vars(r)["name"]
+	ur := UserRatings{Name: name, Ratings: make(map[string]Rating)}
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		u := getUser(tx, name)
+		if u == nil {
+			return nil
+		}
+		found = true
+		ur.Name = u.Name
+		bucket := tx.Bucket(ratings)
+		if bucket == nil {
+			return nil
+		}
+		now := time.Now()
+		for _, cat := range categories {
+			if val := bucket.Get(ratingKey(name, cat)); val != nil {
+				var rt Rating
+				if json.Unmarshal(val, &rt) == nil {
+					ur.Ratings[cat] = rt.idle(now)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such user"}`))
+		return
+	}
+	js, err := json.Marshal(ur)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write(js)
+}
+
+// JSON rating history of a user in a category, oldest
+// first, for graphs
+func RatingsHistory(w http.ResponseWriter,
+	r *http.Request) {
+	vars := mux.Vars(r)
+	name, category := vars["name"], vars["category"]
+	w.Header().Set("Content-Type", "application/json")
+	if !validCategory(category) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such category"}`))
+		return
+	}
+	points := make([]RatingPoint, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ratingHistory)
+		if bucket == nil {
+			return nil
+		}
+		prefix := ratingKey(name, category)
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+			var p RatingPoint
+			if json.Unmarshal(v, &p) == nil {
+				points = append(points, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	js, err := json.Marshal(points)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write(js)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Glickman's "Example of the Glicko-2 system".
+func TestGlickoExample(t *testing.T) {
+	rt := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := rt.period([]glickoGame{
+		{Rating{Rating: 1400, Deviation: 30}, 1},
+		{Rating{Rating: 1550, Deviation: 100}, 0},
+		{Rating{Rating: 1700, Deviation: 300}, 0},
+	})
+	if math.Abs(got.Rating-1464.06) > 0.01 || math.Abs(got.Deviation-151.52) > 0.01 ||
+		math.Abs(got.Volatility-0.05999) > 0.00001 || got.Games != 3 {
+		t.Errorf("got %.2f/%.2f/%.5f after %d games, want 1464.06/151.52/0.05999",
+			got.Rating, got.Deviation, got.Volatility, got.Games)
+	}
+}
+
+func TestRateGame(t *testing.T) {
+	openTestDb(t)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := createUser(name, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rating := func(name, category string) Rating {
+		var rt Rating
+		db.View(func(tx *bolt.Tx) error { rt = getRating(tx, name, category); return nil })
+		return rt
+	}
+	rate := func(rec Record) {
+		t.Helper()
+		if err := rateGame(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unrated games, and games with guests, change nothing
+	rate(Record{Id: "1", Kind: "challenge", White: "alice", Black: "bob", Result: "1-0", Category: "blitz"})
+	rate(Record{Id: "2", Kind: "challenge", White: "alice", Black: guestPrefix + "abc",
+		Result: "1-0", Rated: true, Category: "blitz"})
+	rate(Record{Id: "3", Kind: "challenge", White: "alice", Black: "bob", Rated: true, Category: "blitz"})
+	if rt := rating("alice", "blitz"); rt != newRating() {
+		t.Fatalf("alice rated %+v by unrated games", rt)
+	}
+
+	rate(Record{Id: "4", Kind: "challenge", White: "alice", Black: "bob",
+		Result: "1-0", Rated: true, Category: "blitz"})
+	alice, bob := rating("alice", "blitz"), rating("bob", "blitz")
+	if alice.Games != 1 || bob.Games != 1 || alice.Rating <= 1500 || bob.Rating >= 1500 {
+		t.Errorf("after alice beat bob %+v, %+v", alice, bob)
+	}
+	if math.Abs(alice.Rating-1500-(1500-bob.Rating)) > 0.01 {
+		t.Errorf("uneven change %.2f %.2f", alice.Rating, bob.Rating)
+	}
+	if rt := rating("alice", defaultCategory); rt.Games != 0 {
+		t.Errorf("blitz game rated in %s", defaultCategory)
+	}
+
+	// An AI game the user opted to rate, as black
+	// against Hard, in the default category
+	rate(Record{Id: "5", Kind: "ai", White: aiSeat, Black: "bob", Level: 5,
+		Result: "0-1", Rated: true})
+	bobAi := rating("bob", defaultCategory)
+	if bobAi.Games != 1 || bobAi.Rating <= 1500 || bobAi.Deviation >= defaultDeviation {
+		t.Errorf("bob beat Hard to %+v", bobAi)
+	}
+	rate(Record{Id: "6", Kind: "ai", White: "alice", Black: aiSeat, Level: 5, Result: "0-1"})
+	if rt := rating("alice", defaultCategory); rt.Games != 0 {
+		t.Errorf("unrated AI game rated alice %+v", rt)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/polypmer/ghess"
+)
+
+var records = []byte("records")
+
+// Record is what we keep about a game besides
+// its position. It is stored as json in the records
+// bucket under the same key as the game.
+type Record struct {
+	Id     string   `json:"id"`
+	Kind   string   `json:"kind"`   // "ai" or "challenge"
+	Start  string   `json:"start"`  // FEN before the first move
+	Moves  []string `json:"moves"`  // eg "e2e4", castles onto the rook
+	Result string   `json:"result"` // "1-0", "0-1", "1/2-1/2" or empty
+	Hints  int      `json:"hints"`  // hints asked for
+	Coach  bool     `json:"coach"`  // warn after bad moves
+	// The seats, the user names of the players, guests
+	// by "guest:" and their id, "ai" for the AI.
+	White string `json:"white,omitempty"`
+	Black string `json:"black,omitempty"`
+	// Rated games change their players' ratings in
+	// Category, see rateGame. Level is the level the
+	// AI last played at, in a rated game the level it
+	// was started at.
+	Rated    bool   `json:"rated,omitempty"`
+	Category string `json:"category,omitempty"`
+	Level    int    `json:"level,omitempty"`
+	// Tournament and Round place a tournament game,
+	// see pairRound.
+	Tournament string `json:"tournament,omitempty"`
+	Round      int    `json:"round,omitempty"`
+	// Created is when the game was made and Ended when
+	// it got its Result, see putRecord.
+	Created time.Time `json:"created"`
+	Ended   time.Time `json:"ended,omitempty"`
+}
+
+// rateFrom sets whether rec is rated and its category
+// from the query of r, only users being rated.
+func (rec *Record) rateFrom(r *http.Request) {
+	q := r.URL.Query()
+	rec.Category = q.Get("category")
+	if !validCategory(rec.Category) {
+		rec.Category = defaultCategory
+	}
+	rec.Rated = userFrom(r) != nil && q.Get("rated") == "true"
+}
+
+// gameResult returns the Record result for a
+// finished game, or empty if it isn't over.
+func gameResult(g *ghess.Board) string {
+	switch {
+	case g.Checkmate:
+		return g.Score
+	case g.Draw:
+		return "1/2-1/2"
+	}
+	return ""
+}
+
+// getRecord reads the Record of a game, an unknown
+// game gets an empty Record with its id.
+func getRecord(tx *bolt.Tx, id string) Record {
+	rec := Record{Id: id}
+	bucket := tx.Bucket(records)
+	if bucket == nil {
+		return rec
+	}
+	val := bucket.Get([]byte(id))
+	if val == nil {
+		return rec
+	}
+	json.Unmarshal(val, &rec)
+	return rec
+}
+
+// putRecord writes the Record of a game, and keeps
+// the indexes of its seats up to date. The first
+// write of a Record is when its game was made, the
+// first with a Result when it ended.
+func putRecord(tx *bolt.Tx, rec Record) error {
+	bucket, err := tx.CreateBucketIfNotExists(records)
+	if err != nil {
+		return err
+	}
+	old := getRecord(tx, rec.Id)
+	if rec.Created.IsZero() {
+		rec.Created = old.Created
+	}
+	if rec.Created.IsZero() {
+		rec.Created = time.Now()
+	}
+	if rec.Ended.IsZero() {
+		rec.Ended = old.Ended
+	}
+	if rec.Ended.IsZero() && rec.Result != "" {
+		rec.Ended = time.Now()
+	}
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	err = bucket.Put([]byte(rec.Id), val)
+	if err != nil {
+		return err
+	}
+	return indexRecord(tx, old, rec)
+}
@@ -23,6 +23,7 @@ func NewRouter() *mux.Router {
 		var handler http.Handler
 
 		handler = route.HandlerFunc
+		handler = Authenticate(handler)
 		handler = Logger(handler, route.Name)
 
 		router.Methods(route.Method).
@@ -60,12 +61,180 @@ var routes = Routes{
 		"/play/{id}/{orig}/{dest}/{diff}",
 		PlayGame,
 	},
+	Route{
+		"MovesAi",
+		"GET",
+		"/moves/{id}/{orig}",
+		LegalMoves,
+	},
+	Route{
+		"HintAi",
+		"POST",
+		"/hint/{id}/{diff}",
+		HintGame,
+	},
+	Route{
+		"CoachAi",
+		"POST",
+		"/coach/{id}/{on}",
+		CoachGame,
+	},
+	Route{
+		"Analysis",
+		"GET",
+		"/analysis/{id}",
+		AnalysisGame,
+	},
+	Route{
+		"AnalysisPgn",
+		"GET",
+		"/analysis/{id}/pgn",
+		AnalysisPgn,
+	},
+	Route{
+		"EngineCache",
+		"GET",
+		"/cache/stats",
+		EngineCacheStats,
+	},
+	Route{
+		"Profile",
+		"GET",
+		"/user/{name}",
+		ProfileUser,
+	},
+	Route{
+		"ProfileJson",
+		"GET",
+		"/user/{name}/json",
+		ProfileJson,
+	},
+	Route{
+		"History",
+		"GET",
+		"/user/{name}/games",
+		HistoryUser,
+	},
+	Route{
+		"HistoryJson",
+		"GET",
+		"/user/{name}/games/json",
+		HistoryJson,
+	},
+	Route{
+		"Leaderboards",
+		"GET",
+		"/leaderboards",
+		Leaderboards,
+	},
+	Route{
+		"Leaderboard",
+		"GET",
+		"/leaderboards/{board}",
+		LeaderboardPage,
+	},
+	Route{
+		"LeaderboardJson",
+		"GET",
+		"/leaderboards/{board}/json",
+		LeaderboardJson,
+	},
+	Route{
+		"Ratings",
+		"GET",
+		"/ratings/{name}",
+		RatingsUser,
+	},
+	Route{
+		"RatingHistory",
+		"GET",
+		"/ratings/{name}/{category}",
+		RatingsHistory,
+	},
+	Route{
+		"Tournaments",
+		"GET",
+		"/tournaments",
+		TournamentList,
+	},
+	Route{
+		"NewTournament",
+		"POST",
+		"/tournaments",
+		NewTournament,
+	},
+	Route{
+		"Tournament",
+		"GET",
+		"/tournaments/{id}",
+		TournamentShow,
+	},
+	Route{
+		"TournamentJson",
+		"GET",
+		"/tournaments/{id}/json",
+		TournamentJson,
+	},
+	Route{
+		"JoinTournament",
+		"POST",
+		"/tournaments/{id}/join",
+		JoinTournament,
+	},
+	Route{
+		"LeaveTournament",
+		"POST",
+		"/tournaments/{id}/leave",
+		LeaveTournament,
+	},
+	Route{
+		"StartTournament",
+		"POST",
+		"/tournaments/{id}/start",
+		StartTournament,
+	},
+	Route{
+		"TournamentResult",
+		"POST",
+		"/tournaments/{id}/result",
+		TournamentResult,
+	},
 	Route{
 		"About",
 		"GET",
 		"/about",
 		About,
 	},
+	Route{
+		"RegisterForm",
+		"GET",
+		"/register",
+		Register,
+	},
+	Route{
+		"Register",
+		"POST",
+		"/register",
+		Register,
+	},
+	Route{
+		"LoginForm",
+		"GET",
+		"/login",
+		Login,
+	},
+	Route{
+		"Login",
+		"POST",
+		"/login",
+		Login,
+	},
+	Route{
+		"Logout",
+		"POST",
+		"/logout",
+		Logout,
+	},
 	Route{
 		"NewChallenge",
 		"GET",
@@ -78,6 +247,12 @@ var routes = Routes{
 		"/ws/{id}",
 		WebSocket,
 	},
+	Route{
+		"TournamentSocket",
+		"GET",
+		"/ws/tournament/{id}",
+		TournamentSocket,
+	},
 	Route{
 		"ViewChallenge",
 		"GET",
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// mateScore is what ghess scores a checkmate with.
+// Searches score a mate found n plies away as
+// mateScore - n, so nearer mates are preferred.
+const mateScore = 1000000
+
+// maxDepth bounds iterative deepening.
+const maxDepth = 64
+
+// maxPly bounds how far from the root a search goes,
+// quiescence included.
+const maxPly = 128
+
+// searcher is a single search, which can be stopped
+// from another goroutine or by a deadline.
+type searcher struct {
+	stopped  int32 // set atomically
+	stopCh   <-chan struct{}
+	deadline time.Time
+	nodes    int
+	table    *TransTable
+	weights  *Weights // evalWeights unless set
+	root     []move   // the moves searched at the root
+	buf      [maxPly + 1][256]move
+	scores   [maxPly + 1][256]int
+	killers  [maxPly + 1][2]move // quiet moves which cut off
+	history  [2][64][64]int      // by side, from and to
+	simple   bool                // a bare alpha beta, to compare
+	helper   int                 // numbers Lazy SMP helpers, from 1
+	maxNodes int                 // searched at most, 0 for no limit
+
+	// Weakening, see Profile
+	noise      int
+	seed       uint64
+	rootScores map[move]int // of every root move, if not nil
+	lastScores map[move]int // rootScores of the last complete depth
+}
+
+// Info is what a search tells after every depth.
+type Info struct {
+	Depth int
+	Score int
+	Nodes int
+	Time  time.Duration
+	Pv    [][2]int
+}
+
+// stop asks the search to return as soon as it can.
+func (s *searcher) stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+}
+
+// done returns true once the search should stop.
+func (s *searcher) done() bool {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return true
+	}
+	select {
+	case <-s.stopCh:
+		s.stop()
+		return true
+	default:
+	}
+	if s.maxNodes > 0 && s.nodes >= s.maxNodes {
+		s.stop()
+		return true
+	}
+	if !s.deadline.IsZero() && s.nodes%64 == 0 &&
+		time.Now().After(s.deadline) {
+		s.stop()
+		return true
+	}
+	return false
+}
+
+// eval is the evaluation of p for the player to move,
+// off by up to noise. The noise comes from the key, so
+// it's the same for a position throughout the search.
+func (s *searcher) eval(p *Position) int {
+	score := p.evaluate(s.weights)
+	if p.side == Black {
+		score = -score
+	}
+	if s.noise > 0 {
+		h := (p.hash ^ s.seed) * 0x9e3779b97f4a7c15
+		score += int(h>>33)%(2*s.noise+1) - s.noise
+	}
+	return score
+}
+
+// negamax returns the score of p for the player to
+// move and the principal variation, ply is the distance
+// from the root. After the first move it searches with
+// a null window, principal variation search, and only
+// searches again if a move turns out better.
+func (s *searcher) negamax(p *Position, depth, ply, alpha, beta int) (int, []move) {
+	s.nodes++
+	if ply > 0 && (p.halfmove >= 100 || p.repeated()) {
+		return 0, nil // Fifty moves or a repetition
+	}
+	if ply >= maxPly {
+		return s.eval(p), nil
+	}
+	var hashMove move
+	if s.table != nil {
+		if e, ok := s.table.probe(p.hash); ok {
+			hashMove = e.move
+			score := searchScore(e.score, ply)
+			if ply > 0 && e.depth >= depth &&
+				(e.bound == boundExact ||
+					e.bound == boundLower && score >= beta ||
+					e.bound == boundUpper && score <= alpha) {
+				return score, []move{e.move}
+			}
+		}
+	}
+	inCheck := p.inCheck()
+	if depth <= 0 && !inCheck {
+		if s.simple {
+			return s.eval(p), nil
+		}
+		return s.quiesce(p, ply, alpha, beta), nil
+	}
+	moves := s.root
+	if ply > 0 {
+		moves = p.legalMoves(s.buf[ply][:0])
+	}
+	if len(moves) < 1 {
+		if inCheck {
+			return -(mateScore - ply), nil
+		}
+		return 0, nil // Stalemate
+	}
+	if depth <= 0 {
+		// In check at the horizon, look at every evasion
+		depth = 1
+	}
+	if ply > 0 {
+		s.order(p, moves, ply, hashMove)
+	}
+	origAlpha := alpha
+	bestScore := -mateScore - 1
+	var pv []move
+	for i, m := range moves {
+		if ply > 0 && s.done() {
+			break
+		}
+		p.makeMove(m)
+		var score int
+		var line []move
+		if ply == 0 && s.rootScores != nil {
+			// Every root move's own score, to choose from
+			score, line = s.negamax(p, depth-1, ply+1, -beta, mateScore+1)
+			score = -score
+			s.rootScores[m] = score
+		} else if i == 0 || s.simple {
+			score, line = s.negamax(p, depth-1, ply+1, -beta, -alpha)
+			score = -score
+		} else {
+			score, line = s.negamax(p, depth-1, ply+1, -alpha-1, -alpha)
+			score = -score
+			if score > alpha && score < beta {
+				score, line = s.negamax(p, depth-1, ply+1, -beta, -alpha)
+				score = -score
+			}
+		}
+		p.unmakeMove()
+		if score > bestScore {
+			bestScore = score
+			pv = append([]move{m}, line...)
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			if !s.simple && !p.isCapture(m) && m.promotion() == 0 {
+				s.remember(p.side, m, ply, depth)
+			}
+			break
+		}
+	}
+	// An interrupted search proves nothing
+	if s.table != nil && pv != nil && !s.done() {
+		bound := boundExact
+		switch {
+		case bestScore <= origAlpha:
+			bound = boundUpper
+		case bestScore >= beta:
+			bound = boundLower
+		}
+		s.table.store(p.hash, pv[0], ttScore(bestScore, ply), depth, bound)
+	}
+	return bestScore, pv
+}
+
+// quiesce searches only captures and promotions, so
+// the score isn't taken in the middle of an exchange.
+// The player to move may also stand pat, unless in check.
+func (s *searcher) quiesce(p *Position, ply, alpha, beta int) int {
+	s.nodes++
+	if ply >= maxPly {
+		return s.eval(p)
+	}
+	inCheck := p.inCheck()
+	var moves []move
+	if inCheck {
+		moves = p.legalMoves(s.buf[ply][:0])
+		if len(moves) < 1 {
+			return -(mateScore - ply)
+		}
+	} else {
+		standPat := s.eval(p)
+		if standPat >= beta {
+			return standPat
+		}
+		if standPat > alpha {
+			alpha = standPat
+		}
+		moves = p.tacticalMoves(s.buf[ply][:0])
+	}
+	s.order(p, moves, ply, 0)
+	for _, m := range moves {
+		if s.done() {
+			break
+		}
+		p.makeMove(m)
+		score := -s.quiesce(p, ply+1, -beta, -alpha)
+		p.unmakeMove()
+		if score >= beta {
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// remember records a quiet move which cut off, as a
+// killer of its ply and in the history of its side.
+func (s *searcher) remember(side int, m move, ply, depth int) {
+	if s.killers[ply][0] != m {
+		s.killers[ply][1] = s.killers[ply][0]
+		s.killers[ply][0] = m
+	}
+	s.history[side][m.from()][m.to()] += depth * depth
+}
+
+// Move ordering, from the first tried
+const (
+	orderHash    = 1 << 30
+	orderCapture = 1 << 24 // plus MVV-LVA
+	orderKiller  = 1 << 22
+)
+
+// order sorts moves by how likely they are to cut
+// off: the hash move, captures of the most valuable
+// victim by the least valuable attacker, promotions,
+// killers and then by history.
+func (s *searcher) order(p *Position, moves []move, ply int, hashMove move) {
+	if s.simple {
+		// Only the hash move, as before
+		for i, m := range moves {
+			if m == hashMove && hashMove != 0 {
+				moves[0], moves[i] = moves[i], moves[0]
+				break
+			}
+		}
+		return
+	}
+	scores := s.scores[ply][:len(moves)]
+	for i, m := range moves {
+		switch {
+		case m == hashMove:
+			scores[i] = orderHash
+		case p.isCapture(m) || m.promotion() != 0:
+			victim := Pawn
+			if pc := p.board[m.to()]; pc != Empty {
+				victim = pc % 6
+			} else if m.flag() != flagEnPassant {
+				victim = -1 // a quiet promotion
+			}
+			scores[i] = orderCapture + 8*(victim+1) - p.board[m.from()]%6
+			if m.promotion() != 0 {
+				scores[i] += 8 * m.promotion()
+			}
+		case m == s.killers[ply][0]:
+			scores[i] = orderKiller + 1
+		case m == s.killers[ply][1]:
+			scores[i] = orderKiller
+		default:
+			scores[i] = s.history[p.side][m.from()][m.to()]
+			if scores[i] >= orderKiller {
+				scores[i] = orderKiller - 1
+			}
+		}
+	}
+	// Insertion sort, the lists are short
+	for i := 1; i < len(moves); i++ {
+		m, sc := moves[i], scores[i]
+		j := i - 1
+		for ; j >= 0 && scores[j] < sc; j-- {
+			moves[j+1], scores[j+1] = moves[j], scores[j]
+		}
+		moves[j+1], scores[j+1] = m, sc
+	}
+}
+
+// rootMoves returns the legal moves of p which ghess
+// will play on b, so no underpromotions.
+func rootMoves(p *Position, b *ghess.Board) []move {
+	var moves []move
+	for _, m := range p.legalMoves(nil) {
+		if m.promotion() != 0 && m.promotion() != Queen {
+			continue
+		}
+		orig, dest := m.ghessMove()
+		if ghess.CopyBoard(b).Move(orig, dest) != nil {
+			continue
+		}
+		moves = append(moves, m)
+	}
+	return moves
+}
+
+// ghessPv returns a principal variation in ghess
+// coordinates.
+func ghessPv(pv []move) [][2]int {
+	line := make([][2]int, len(pv))
+	for i, m := range pv {
+		line[i][0], line[i][1] = m.ghessMove()
+	}
+	return line
+}
+
+// iterate searches b one ply deeper at a time, up to
+// depth, until it's stopped. The first move of the
+// last complete depth leads, info is called after each.
+func (s *searcher) iterate(b *ghess.Board, depth int, info func(Info)) Result {
+	start := time.Now()
+	res := Result{}
+	p, err := positionOf(b)
+	if err != nil {
+		return res
+	}
+	if s.weights == nil {
+		s.weights = evalWeights
+	}
+	s.root = rootMoves(p, b)
+	s.order(p, s.root, 0, 0)
+	if s.table != nil && s.helper == 0 {
+		s.table.newSearch()
+	}
+	var pv []move
+	if depth < 1 || depth > maxDepth {
+		depth = maxDepth
+	}
+	// Odd helpers start a ply ahead
+	for d := 1 + s.helper%2; d <= depth; d++ {
+		if s.rootScores != nil {
+			s.rootScores = make(map[move]int, len(s.root))
+		}
+		score, line := s.negamax(p, d, 0, -mateScore-1, mateScore+1)
+		// An unfinished depth is only better than nothing
+		if s.done() && pv != nil {
+			break
+		}
+		if len(line) < 1 {
+			break // No moves at all
+		}
+		pv = line
+		orig, dest := pv[0].ghessMove()
+		res = Result{Orig: orig, Dest: dest, Score: score, Depth: d, Pv: ghessPv(pv)}
+		s.lastScores = s.rootScores
+		if info != nil {
+			info(Info{Depth: d, Score: score, Nodes: s.nodes,
+				Time: time.Since(start), Pv: res.Pv})
+		}
+		if s.done() || score >= mateScore-d {
+			break
+		}
+		// Search the best move first next time
+		for i, m := range s.root {
+			if m == pv[0] {
+				copy(s.root[1:i+1], s.root[:i])
+				s.root[0] = m
+				break
+			}
+		}
+	}
+	return res
+}
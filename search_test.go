@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// The Win at Chess positions the search should find
+// at depth 4.
+var easyTactics = []string{"WAC.001", "WAC.003", "WAC.004", "WAC.005", "WAC.008", "WAC.010"}
+
+func TestSolveTactics(t *testing.T) {
+	cases := make(map[string]epdCase)
+	for _, line := range tacticsSuite {
+		c, err := parseEpd(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases[c.Id] = c
+	}
+	table := NewTransTable(16)
+	for _, id := range easyTactics {
+		c, ok := cases[id]
+		if !ok {
+			t.Fatalf("no position %s", id)
+		}
+		if found, ok := solve(c, table, false, 0, 4); !ok {
+			t.Errorf("%s: found %s, want %v", id, found, c.Best)
+		}
+	}
+}
+
+// BenchmarkSearch is the Search of growser bench, from
+// an empty table each time.
+func BenchmarkSearch(b *testing.B) {
+	table := NewTransTable(16)
+	for _, pos := range benchPositions {
+		b.Run(pos.Name, func(b *testing.B) {
+			game, err := loadFen(pos.Fen)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				table.Clear()
+				b.StartTimer()
+				_, err := NegamaxEngine{Table: table}.BestMove(&game, Limits{Depth: 4})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
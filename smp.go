@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// threadPool caps the helper threads searching at once,
+// over every game. A search always has its own thread,
+// so a busy server only loses the helpers.
+type threadPool struct {
+	tokens chan struct{}
+}
+
+// maxThreads bounds the threads a search asks for.
+const maxThreads = 256
+
+// searchPool is shared by all the searches.
+var searchPool = newThreadPool(runtime.NumCPU())
+
+// levelThreads are the threads a move of a difficulty
+// searches with, one unless set.
+var levelThreads = map[int]int{5: runtime.NumCPU()}
+
+func newThreadPool(n int) *threadPool {
+	if n < 0 {
+		n = 0
+	}
+	return &threadPool{tokens: make(chan struct{}, n)}
+}
+
+// acquire takes up to n threads, as many as are idle,
+// without waiting.
+func (t *threadPool) acquire(n int) int {
+	got := 0
+	for ; got < n; got++ {
+		select {
+		case t.tokens <- struct{}{}:
+		default:
+			return got
+		}
+	}
+	return got
+}
+
+// release gives back n threads.
+func (t *threadPool) release(n int) {
+	for i := 0; i < n; i++ {
+		<-t.tokens
+	}
+}
+
+// ParseSearchThreads reads a -searchthreads list such
+// as "4=2,5=8" into levelThreads.
+func ParseSearchThreads(list string) error {
+	for _, pair := range strings.Split(list, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			return errors.New("Invalid search threads " + pair)
+		}
+		diff, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return err
+		}
+		threads, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return err
+		}
+		levelThreads[diff] = threads
+	}
+	return nil
+}
+
+// threadsFor returns the threads difficulty diff
+// searches with.
+func threadsFor(diff int) int {
+	if n, ok := levelThreads[diff]; ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// lazySmp searches b with the threads of l, Lazy SMP:
+// helpers search the same position over the shared
+// table, half of them a ply ahead and without a depth,
+// and fill it with what the main search needs next.
+// Only the main search's move counts, the helpers stop
+// with it.
+func lazySmp(b *ghess.Board, table *TransTable, w *Weights, l Limits) Result {
+	var deadline time.Time
+	if l.MoveTime > 0 {
+		deadline = time.Now().Add(l.MoveTime)
+	}
+	helpers := searchPool.acquire(l.Threads - 1)
+	// Moves come before ponders
+	if helpers < l.Threads-1 && stopPonders() > 0 {
+		helpers += searchPool.acquire(l.Threads - 1 - helpers)
+	}
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 1; i <= helpers; i++ {
+		h := &searcher{stopCh: quit, table: table, weights: w,
+			deadline: deadline, helper: i}
+		// ghess writes to a Board it reads, so each has its own
+		hb := ghess.CopyBoard(b)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.iterate(hb, 0, nil)
+		}()
+	}
+	s := &searcher{stopCh: l.Stop, table: table, weights: w, deadline: deadline}
+	res := s.iterate(b, l.Depth, l.Info)
+	close(quit)
+	wg.Wait()
+	searchPool.release(helpers)
+	return res
+}
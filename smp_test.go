@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/polypmer/ghess"
+)
+
+func TestThreadPool(t *testing.T) {
+	pool := newThreadPool(3)
+	if n := pool.acquire(2); n != 2 {
+		t.Fatalf("acquired %d of 2", n)
+	}
+	if n := pool.acquire(2); n != 1 {
+		t.Fatalf("acquired %d with 1 idle", n)
+	}
+	if n := pool.acquire(1); n != 0 {
+		t.Fatalf("acquired %d from a busy pool", n)
+	}
+	pool.release(3)
+	if n := pool.acquire(5); n != 3 {
+		t.Errorf("acquired %d of 3 after release", n)
+	}
+}
+
+func TestParseSearchThreads(t *testing.T) {
+	defer func(saved map[int]int) { levelThreads = saved }(levelThreads)
+	levelThreads = map[int]int{}
+	if err := ParseSearchThreads("4=2,5=8,"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int{4: 2, 5: 8}; !reflect.DeepEqual(levelThreads, want) {
+		t.Errorf("%v, want %v", levelThreads, want)
+	}
+	if threadsFor(4) != 2 || threadsFor(3) != 1 {
+		t.Errorf("threads %d and %d, want 2 and 1", threadsFor(4), threadsFor(3))
+	}
+	for _, bad := range []string{"4", "x=2", "4=y", "4=2=1"} {
+		if err := ParseSearchThreads(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+// Helpers mustn't change the move, and give their
+// threads back.
+func TestLazySmp(t *testing.T) {
+	defer func(saved *threadPool) { searchPool = saved }(searchPool)
+	searchPool = newThreadPool(3)
+	game, err := loadFen("r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NegamaxEngine{Table: NewTransTable(16)}
+	res, err := e.BestMove(&game, Limits{Depth: 3, Threads: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Orig != ghess.PgnToCoordMap["h5"] || res.Dest != ghess.PgnToCoordMap["f7"] {
+		t.Errorf("played %d %d, want h5f7", res.Orig, res.Dest)
+	}
+	if n := searchPool.acquire(3); n != 3 {
+		t.Errorf("%d threads back of 3", n)
+	}
+}
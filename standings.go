@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Standing is a player's place in a tournament. A bye
+// scores a point but adds nothing to the tiebreaks.
+type Standing struct {
+	Rank   int     `json:"rank"`
+	Name   string  `json:"name"`
+	Seed   int     `json:"seed"`
+	Points float64 `json:"points"`
+	// Buchholz is the sum of the points of everyone
+	// played, SonnebornBerger that of those beaten and
+	// half that of those drawn.
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Played          int     `json:"played"`
+	Wins            int     `json:"wins"`
+	Draws           int     `json:"draws"`
+	Losses          int     `json:"losses"`
+	// For pairing, the colours so far, eg "wbw", who
+	// they were paired with and whether they had a bye.
+	colours   string
+	opponents map[string]bool
+	bye       bool
+	games     []playedGame
+}
+
+// playedGame is a finished game of a Standing.
+type playedGame struct {
+	opponent string
+	score    float64
+}
+
+// standingsOf returns the standings of t, best first,
+// from the results in the records of its games, and
+// sets the Result of each Pairing from them.
+func standingsOf(tx *bolt.Tx, t *Tournament) []Standing {
+	standings := make([]Standing, len(t.Players))
+	by := make(map[string]*Standing)
+	for i, p := range t.Players {
+		standings[i] = Standing{Name: p.Name, Seed: i + 1,
+			opponents: make(map[string]bool)}
+		by[p.Name] = &standings[i]
+	}
+	for _, round := range t.Pairings {
+		for i := range round {
+			p := &round[i]
+			w, b := by[p.White], by[p.Black]
+			if w == nil {
+				continue
+			}
+			if p.Black == "" {
+				w.Points++
+				w.bye = true
+				p.Result = "bye"
+				continue
+			}
+			if b == nil {
+				continue
+			}
+			w.colours += "w"
+			b.colours += "b"
+			w.opponents[b.Name], b.opponents[w.Name] = true, true
+			p.Result = getRecord(tx, p.Game).Result
+			score, ok := resultScore(p.Result)
+			if !ok {
+				continue
+			}
+			w.add(b.Name, score)
+			b.add(w.Name, 1-score)
+		}
+	}
+	for i := range standings {
+		s := &standings[i]
+		for _, g := range s.games {
+			opp := by[g.opponent].Points
+			s.Buchholz += opp
+			s.SonnebornBerger += g.score * opp
+		}
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		switch {
+		case a.Points != b.Points:
+			return a.Points > b.Points
+		case a.Buchholz != b.Buchholz:
+			return a.Buchholz > b.Buchholz
+		case a.SonnebornBerger != b.SonnebornBerger:
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Seed < b.Seed
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
+
+// add counts a finished game against opponent.
+func (s *Standing) add(opponent string, score float64) {
+	s.Points += score
+	s.Played++
+	switch outcomeOf(score) {
+	case "win":
+		s.Wins++
+	case "draw":
+		s.Draws++
+	default:
+		s.Losses++
+	}
+	s.games = append(s.games, playedGame{opponent, score})
+}
+
+// StandingsHub sends the standings of tournaments to
+// the pages watching them, see broadcastStandings.
+type StandingsHub struct {
+	watchers   map[*Watcher]bool
+	broadcast  chan Bulletin
+	register   chan *Watcher
+	unregister chan *Watcher
+}
+
+// Bulletin is a message for the watchers of a tournament.
+type Bulletin struct {
+	Tournament string
+	Message    []byte
+}
+
+// Watcher is a page watching a tournament.
+type Watcher struct {
+	hub        *StandingsHub
+	tournament string
+	conn       *websocket.Conn
+	send       chan []byte
+}
+
+// newStandingsHub returns a pointer to a new StandingsHub
+func newStandingsHub() *StandingsHub {
+	return &StandingsHub{
+		watchers:   make(map[*Watcher]bool),
+		broadcast:  make(chan Bulletin),
+		register:   make(chan *Watcher),
+		unregister: make(chan *Watcher),
+	}
+}
+
+// run adds and drops watchers, and passes each
+// Bulletin on to the watchers of its tournament.
+func (h *StandingsHub) run() {
+	for {
+		select {
+		case w := <-h.register:
+			h.watchers[w] = true
+		case w := <-h.unregister:
+			if _, ok := h.watchers[w]; ok {
+				delete(h.watchers, w)
+				close(w.send)
+			}
+		case b := <-h.broadcast:
+			for w := range h.watchers {
+				if w.tournament != b.Tournament {
+					continue
+				}
+				select {
+				case w.send <- b.Message:
+				default:
+					close(w.send)
+					delete(h.watchers, w)
+				}
+			}
+		}
+	}
+}
+
+// broadcastStandings sends the tournament of id, its
+// rounds and standings, to the pages watching it.
+func broadcastStandings(id string) {
+	if standingsHub == nil {
+		return
+	}
+	var view *TournamentView
+	err := db.View(func(tx *bolt.Tx) error {
+		view = viewTournament(tx, id)
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	if view == nil {
+		return
+	}
+	js, err := json.Marshal(view)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	standingsHub.broadcast <- Bulletin{Tournament: id, Message: js}
+}
+
+// readPump reads until the page goes, watchers only
+// listen.
+func (w *Watcher) readPump() {
+	defer func() {
+		w.hub.unregister <- w
+		w.conn.Close()
+	}()
+	w.conn.SetReadLimit(maxMessageSize)
+	w.conn.SetReadDeadline(time.Now().Add(pongWait))
+	w.conn.SetPongHandler(func(string) error { w.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		_, _, err := w.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+				log.Printf("error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// writePump writes the bulletins to the page.
+func (w *Watcher) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		w.conn.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-w.send:
+			w.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				w.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := w.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			w.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := w.conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Websocket of a tournament's standings, sent whenever
+// one of its games finishes or a round is paired
+func TournamentSocket(w http.ResponseWriter,
+	r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	watcher := &Watcher{hub: standingsHub, tournament: mux.Vars(r)["id"],
+		conn: conn, send: make(chan []byte, 16)}
+	watcher.hub.register <- watcher
+	go watcher.writePump()
+	watcher.readPump()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// A small tournament worked out by hand. Besides the
+// games, E has a bye in the first round.
+//
+//	     A    B    C    D   Pts  Buchholz  S-B
+//	A    .    0    ½    1   1.5  4.5       1.25
+//	B    1    .    ½    1   2.5  3.5       2.75
+//	C    ½    ½    .    ½   1.5  4.5       2.25
+//	D    0    0    ½    .   0.5  5.5       0.75
+//	E    bye                1    0         0
+func TestStandings(t *testing.T) {
+	openTestDb(t)
+	games := []Record{
+		{Id: "1", White: "A", Black: "D", Result: "1-0"},
+		{Id: "2", White: "B", Black: "C", Result: "1/2-1/2"},
+		{Id: "3", White: "C", Black: "A", Result: "1/2-1/2"},
+		{Id: "4", White: "D", Black: "B", Result: "0-1"},
+		{Id: "5", White: "A", Black: "B", Result: "0-1"},
+		{Id: "6", White: "C", Black: "D", Result: "1/2-1/2"},
+		{Id: "7", White: "A", Black: "E"}, // unfinished
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range games {
+			rec.Kind = "challenge"
+			if err := putRecord(tx, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tour := &Tournament{
+		Players: []Entrant{{Name: "A"}, {Name: "B"}, {Name: "C"}, {Name: "D"}, {Name: "E"}},
+		Pairings: [][]Pairing{
+			{{Board: 1, White: "A", Black: "D", Game: "1"},
+				{Board: 2, White: "B", Black: "C", Game: "2"},
+				{Board: 3, White: "E"}},
+			{{Board: 1, White: "C", Black: "A", Game: "3"},
+				{Board: 2, White: "D", Black: "B", Game: "4"}},
+			{{Board: 1, White: "A", Black: "B", Game: "5"},
+				{Board: 2, White: "C", Black: "D", Game: "6"}},
+			{{Board: 1, White: "A", Black: "E", Game: "7"}},
+		},
+	}
+	var standings []Standing
+	db.View(func(tx *bolt.Tx) error {
+		standings = standingsOf(tx, tour)
+		return nil
+	})
+	want := []struct {
+		name                 string
+		points, buchholz, sb float64
+		wins, draws, losses  int
+	}{
+		{"B", 2.5, 3.5, 2.75, 2, 1, 0},
+		{"C", 1.5, 4.5, 2.25, 0, 3, 0}, // ahead of A by S-B
+		{"A", 1.5, 4.5, 1.25, 1, 1, 1},
+		{"E", 1, 0, 0, 0, 0, 0},
+		{"D", 0.5, 5.5, 0.75, 0, 1, 2},
+	}
+	if len(standings) != len(want) {
+		t.Fatalf("%d standings", len(standings))
+	}
+	for i, w := range want {
+		s := standings[i]
+		if s.Rank != i+1 || s.Name != w.name || s.Points != w.points ||
+			s.Buchholz != w.buchholz || s.SonnebornBerger != w.sb ||
+			s.Wins != w.wins || s.Draws != w.draws || s.Losses != w.losses {
+			t.Errorf("rank %d: %+v, want %+v", i+1, s, w)
+		}
+	}
+	if r := tour.Pairings[0][2].Result; r != "bye" {
+		t.Errorf("bye result %q", r)
+	}
+	if r := tour.Pairings[1][1].Result; r != "0-1" {
+		t.Errorf("D-B result %q", r)
+	}
+	if r := tour.Pairings[3][0].Result; r != "" {
+		t.Errorf("unfinished result %q", r)
+	}
+}
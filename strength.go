@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// Profile is how strongly a difficulty plays. Besides
+// its depth it can play like a person: its evaluation
+// is off by up to Noise, now and then it picks another
+// of its TopN moves, and sometimes it misses a tactic.
+type Profile struct {
+	Name  string
+	Depth int
+	Nodes int // searched at most, 0 for no limit
+	// Noise is the most centipawns a position's
+	// evaluation is off by, differently every move.
+	Noise int
+	// TopN moves are chosen among with the probability
+	// Chance, if within Margin centipawns of the best.
+	TopN   int
+	Chance float64
+	Margin int
+	// Miss is the probability of searching a ply less
+	// without quiescence, blind to the last capture.
+	Miss float64
+	// Rating is an estimate of the Elo the profile
+	// plays at, see levels.
+	Rating int
+}
+
+// levels are the profiles of the difficulties, which
+// computer.html calls Beginner to Hard.
+//
+// The ratings are measured by growser calibrate, which
+// plays each level against the next 200 games at 300ms
+// a move and chains the Elo differences down from Hard,
+// taken as 1600. With their 95% error:
+//
+//	Medium    1380 +/- 43, +12 =64 -124 against Hard
+//	Easy      1139 +/- 65, +17 =46 -137 against Medium
+//	Novice     994 +/- 78, +33 =55 -112 against Easy
+//	Beginner   787 +/- 92, +27 =39 -134 against Novice
+//
+// Calibrate again after changing a profile.
+var levels = map[int]*Profile{
+	1: {Name: "Beginner", Depth: 3, Noise: 100,
+		TopN: 4, Chance: 0.35, Margin: 200, Miss: 0.2, Rating: 787},
+	2: {Name: "Novice", Depth: 3, Noise: 60,
+		TopN: 3, Chance: 0.25, Margin: 150, Miss: 0.12, Rating: 994},
+	3: {Name: "Easy", Depth: 3, Noise: 30,
+		TopN: 3, Chance: 0.15, Margin: 80, Miss: 0.05, Rating: 1139},
+	4: {Name: "Medium", Depth: 4, Noise: 25,
+		TopN: 2, Chance: 0.1, Margin: 60, Miss: 0.05, Rating: 1380},
+	5: {Name: "Hard", Depth: 5, Rating: 1600},
+}
+
+// weakened returns true if h plays worse than its search.
+func (h *Profile) weakened() bool {
+	return h.Nodes > 0 || h.Noise > 0 || h.Chance > 0 || h.Miss > 0
+}
+
+// play searches g like h, on a table of its own so
+// its noise doesn't reach other searches.
+func (h *Profile) play(g *ghess.Board, w *Weights, l Limits) Result {
+	s := &searcher{stopCh: l.Stop, table: NewTransTable(1), weights: w,
+		noise: h.Noise, seed: rand.Uint64(), maxNodes: h.Nodes}
+	if l.MoveTime > 0 {
+		s.deadline = time.Now().Add(l.MoveTime)
+	}
+	depth := l.Depth
+	if rand.Float64() < h.Miss {
+		s.simple = true
+		if depth > 1 {
+			depth--
+		}
+	}
+	pick := h.TopN > 1 && rand.Float64() < h.Chance
+	if pick {
+		s.rootScores = make(map[move]int)
+	}
+	res := s.iterate(g, depth, l.Info)
+	if !pick || len(s.lastScores) < 2 {
+		return res
+	}
+	// Another of the best moves
+	moves := make([]move, 0, len(s.lastScores))
+	for m := range s.lastScores {
+		moves = append(moves, m)
+	}
+	sort.Slice(moves, func(i, j int) bool {
+		return s.lastScores[moves[i]] > s.lastScores[moves[j]]
+	})
+	best := s.lastScores[moves[0]]
+	n := 1
+	for n < h.TopN && n < len(moves) && s.lastScores[moves[n]] >= best-h.Margin {
+		n++
+	}
+	m := moves[rand.Intn(n)]
+	orig, dest := m.ghessMove()
+	return Result{Orig: orig, Dest: dest, Score: s.lastScores[m],
+		Depth: res.Depth, Pv: [][2]int{{orig, dest}}}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/polypmer/ghess"
+)
+
+func TestLevels(t *testing.T) {
+	for diff := 1; diff <= 5; diff++ {
+		h, ok := levels[diff]
+		if !ok {
+			t.Fatalf("no level %d", diff)
+		}
+		if diff > 1 && h.Rating <= levels[diff-1].Rating {
+			t.Errorf("%s rated %d, below %s", h.Name, h.Rating, levels[diff-1].Name)
+		}
+		if h.weakened() != (diff < 5) {
+			t.Errorf("%s weakened %v", h.Name, h.weakened())
+		}
+	}
+}
+
+// Hard finds a mate in one, and however weak a
+// profile plays legal moves.
+func TestProfilePlay(t *testing.T) {
+	game, err := loadFen("r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ghess.PgnToCoordMap
+	res := levels[5].play(&game, evalWeights, Limits{Depth: 3})
+	if res.Orig != m["h5"] || res.Dest != m["f7"] {
+		t.Errorf("Hard played %d %d, want h5f7", res.Orig, res.Dest)
+	}
+	game = ghess.NewBoard()
+	wild := &Profile{Depth: 2, Noise: 200, TopN: 4, Chance: 1, Margin: 1000, Miss: 0.5}
+	seen := make(map[[2]int]bool)
+	for i := 0; i < 20; i++ {
+		res := wild.play(&game, evalWeights, Limits{Depth: 2})
+		b := ghess.CopyBoard(&game)
+		if err := b.Move(res.Orig, res.Dest); err != nil {
+			t.Fatalf("played %d %d: %s", res.Orig, res.Dest, err)
+		}
+		seen[[2]int{res.Orig, res.Dest}] = true
+	}
+	if len(seen) < 2 {
+		t.Error("always the same move with Chance 1")
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+// tournaments holds every Tournament as json by its id.
+var tournaments = []byte("tournaments")
+
+// Kinds of tournament
+const (
+	swissKind      = "swiss"
+	roundRobinKind = "roundrobin"
+)
+
+// States of a tournament
+const (
+	registering = "registering"
+	playing     = "playing"
+	finished    = "finished"
+)
+
+// maxSwissRounds is the most rounds a Swiss may have.
+const maxSwissRounds = 15
+
+var (
+	errTournamentName = errors.New("Tournaments need a name of up to 50 characters")
+	errTournamentKind = errors.New("Tournaments are swiss or roundrobin")
+	errSwissRounds    = fmt.Errorf("A Swiss has 1 to %d rounds", maxSwissRounds)
+	errLoggedOut      = errors.New("Log in to take part in tournaments")
+	errNotOrganiser   = errors.New("Only the organiser can do that")
+	errNotRegistering = errors.New("Registration has closed")
+	errTooFew         = errors.New("A tournament needs at least 2 players")
+	errTooManyRounds  = errors.New("A Swiss needs more players than rounds")
+	errNoSuchGame     = errors.New("That game isn't in this tournament, or is over")
+	errBadResult      = errors.New("Results are 1-0, 0-1 or 1/2-1/2")
+)
+
+// Tournament is a Swiss or round robin of users. Its
+// rounds are played as challenges with their seats
+// taken, and its results are those of their records.
+type Tournament struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Rounds    int       `json:"rounds"`
+	Category  string    `json:"category"`
+	Rated     bool      `json:"rated"`
+	Organiser string    `json:"organiser"`
+	State     string    `json:"state"`
+	Players   []Entrant `json:"players"` // in seed order once started
+	// Pairings are the pairings of each round so far.
+	Pairings [][]Pairing `json:"pairings"`
+	Created  time.Time   `json:"created"`
+}
+
+// Entrant is a player of a tournament, with the rating
+// they were seeded by.
+type Entrant struct {
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating,omitempty"`
+}
+
+// Pairing is a game of a round, or a bye with no Black
+// or Game. Result is from the game's record, see
+// standingsOf.
+type Pairing struct {
+	Board  int    `json:"board"`
+	White  string `json:"white"`
+	Black  string `json:"black,omitempty"`
+	Game   string `json:"game,omitempty"`
+	Result string `json:"result,omitempty"`
+}
+
+// getTournament reads the tournament of id, nil if
+// there is none.
+func getTournament(tx *bolt.Tx, id string) *Tournament {
+	bucket := tx.Bucket(tournaments)
+	if bucket == nil {
+		return nil
+	}
+	val := bucket.Get([]byte(id))
+	if val == nil {
+		return nil
+	}
+	var t Tournament
+	if json.Unmarshal(val, &t) != nil {
+		return nil
+	}
+	return &t
+}
+
+// putTournament writes t.
+func putTournament(tx *bolt.Tx, t *Tournament) error {
+	bucket, err := tx.CreateBucketIfNotExists(tournaments)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(t.Id), val)
+}
+
+// entrant returns the index of name in the players of
+// t, -1 if they haven't entered.
+func (t *Tournament) entrant(name string) int {
+	for i, p := range t.Players {
+		if strings.EqualFold(p.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// startTournament closes registration, seeds the
+// players by their rating in its category and pairs
+// the first round.
+func startTournament(tx *bolt.Tx, t *Tournament) error {
+	n := len(t.Players)
+	switch {
+	case n < 2:
+		return errTooFew
+	case t.Kind == swissKind && t.Rounds >= n:
+		return errTooManyRounds
+	case t.Kind == roundRobinKind:
+		t.Rounds = roundRobinRounds(n)
+	}
+	now := time.Now()
+	for i := range t.Players {
+		t.Players[i].Rating = getRating(tx, t.Players[i].Name, t.Category).idle(now).Rating
+	}
+	sort.SliceStable(t.Players, func(i, j int) bool {
+		return t.Players[i].Rating > t.Players[j].Rating
+	})
+	t.State = playing
+	return pairRound(tx, t)
+}
+
+// pairRound pairs the next round of t and makes its
+// games, challenges with the players in their seats.
+func pairRound(tx *bolt.Tx, t *Tournament) error {
+	round := len(t.Pairings) + 1
+	var pairs []Pairing
+	if t.Kind == roundRobinKind {
+		var names []string
+		for _, p := range t.Players {
+			names = append(names, p.Name)
+		}
+		pairs = roundRobinPairings(names, round)
+	} else {
+		pairs = swissPairings(standingsOf(tx, t))
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("challenges"))
+	if err != nil {
+		return err
+	}
+	for i := range pairs {
+		p := &pairs[i]
+		if p.Black == "" {
+			continue
+		}
+		p.Game = fmt.Sprintf("t%s-%d-%d", t.Id, round, p.Board)
+		err = bucket.Put([]byte(p.Game), []byte(startFen))
+		if err != nil {
+			return err
+		}
+		err = putRecord(tx, Record{Id: p.Game, Kind: "challenge",
+			Start: startFen, White: p.White, Black: p.Black,
+			Rated: t.Rated, Category: t.Category,
+			Tournament: t.Id, Round: round})
+		if err != nil {
+			return err
+		}
+	}
+	t.Pairings = append(t.Pairings, pairs)
+	return nil
+}
+
+// advanceTournament pairs the next round of t once
+// every game of the last one is over, or after the
+// last round finishes t.
+func advanceTournament(tx *bolt.Tx, t *Tournament) error {
+	if t.State != playing || len(t.Pairings) == 0 {
+		return nil
+	}
+	standingsOf(tx, t)
+	for _, p := range t.Pairings[len(t.Pairings)-1] {
+		if p.Result == "" {
+			return nil
+		}
+	}
+	if len(t.Pairings) >= t.Rounds {
+		t.State = finished
+		return nil
+	}
+	return pairRound(tx, t)
+}
+
+// tournamentGameOver moves on the tournament of a
+// finished game, and tells its watchers.
+func tournamentGameOver(rec Record) {
+	if rec.Tournament == "" {
+		return
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		t := getTournament(tx, rec.Tournament)
+		if t == nil {
+			return nil
+		}
+		err := advanceTournament(tx, t)
+		if err != nil {
+			return err
+		}
+		return putTournament(tx, t)
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	broadcastStandings(rec.Tournament)
+}
+
+// TournamentView is a tournament with its standings,
+// as its page, JSON and websocket show it.
+type TournamentView struct {
+	Type string `json:"type"` // "standings", for the websocket
+	*Tournament
+	Standings []Standing `json:"standings"`
+}
+
+// viewTournament returns the view of the tournament of
+// id, nil if there is none.
+func viewTournament(tx *bolt.Tx, id string) *TournamentView {
+	t := getTournament(tx, id)
+	if t == nil {
+		return nil
+	}
+	return &TournamentView{Type: "standings", Tournament: t,
+		Standings: standingsOf(tx, t)}
+}
+
+// TournamentsPage lists the tournaments, with the form
+// for a new one.
+type TournamentsPage struct {
+	User        *User
+	Tournaments []*Tournament
+	Categories  []string
+	Error       string
+}
+
+// TournamentPage is a tournament as its page shows it
+// to the user looking.
+type TournamentPage struct {
+	*TournamentView
+	User       *User
+	Entered    bool
+	Organising bool // the user is the organiser
+	Error      string
+}
+
+// showTournaments executes the tournaments template.
+func showTournaments(w http.ResponseWriter, r *http.Request, page TournamentsPage) {
+	page.User, page.Categories = userFrom(r), categories
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tournaments)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var t Tournament
+			if json.Unmarshal(v, &t) == nil {
+				page.Tournaments = append(page.Tournaments, &t)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	sort.Slice(page.Tournaments, func(i, j int) bool {
+		return page.Tournaments[i].Created.After(page.Tournaments[j].Created)
+	})
+	t, err := profileTemplate("tournaments.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	err = t.Execute(w, page)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// showTournament executes the tournament template for
+// the tournament of id, or answers 404.
+func showTournament(w http.ResponseWriter, r *http.Request, id, msg string) {
+	page := TournamentPage{User: userFrom(r), Error: msg}
+	err := db.View(func(tx *bolt.Tx) error {
+		page.TournamentView = viewTournament(tx, id)
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	if page.TournamentView == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if page.User != nil {
+		page.Entered = page.entrant(page.User.Name) >= 0
+		page.Organising = page.User.Name == page.Organiser
+	}
+	t, err := profileTemplate("tournament.html")
+	if err != nil {
+		fmt.Printf("Error %s Templates", err)
+		return
+	}
+	err = t.Execute(w, page)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Tournaments, and the form for a new one
+func TournamentList(w http.ResponseWriter,
+	r *http.Request) {
+	showTournaments(w, r, TournamentsPage{})
+}
+
+// NewTournament makes a tournament from the form, name,
+// kind, rounds of a Swiss, category and rated, which
+// the user making it organises.
+func NewTournament(w http.ResponseWriter,
+	r *http.Request) {
+	u := userFrom(r)
+	t := &Tournament{Name: strings.TrimSpace(r.PostFormValue("name")),
+		Kind: r.PostFormValue("kind"), Category: r.PostFormValue("category"),
+		Rated: r.PostFormValue("rated") == "true", State: registering,
+		Pairings: make([][]Pairing, 0), Created: time.Now()}
+	if !validCategory(t.Category) {
+		t.Category = defaultCategory
+	}
+	var err error
+	switch {
+	case u == nil:
+		err = errLoggedOut
+	case t.Name == "" || len(t.Name) > 50:
+		err = errTournamentName
+	case t.Kind != swissKind && t.Kind != roundRobinKind:
+		err = errTournamentKind
+	case t.Kind == swissKind:
+		t.Rounds, err = strconv.Atoi(r.PostFormValue("rounds"))
+		if err != nil || t.Rounds < 1 || t.Rounds > maxSwissRounds {
+			err = errSwissRounds
+		}
+	}
+	if err == nil {
+		t.Organiser = u.Name
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(tournaments)
+			if err != nil {
+				return err
+			}
+			n, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			t.Id = strconv.FormatUint(n, 10)
+			return putTournament(tx, t)
+		})
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		showTournaments(w, r, TournamentsPage{Error: err.Error()})
+		return
+	}
+	http.Redirect(w, r, "/tournaments/"+t.Id, http.StatusSeeOther)
+}
+
+// changeTournament runs change on the tournament of the
+// request for its logged in user, then shows it again,
+// with the error if there was one. It returns true if
+// the change was made.
+func changeTournament(w http.ResponseWriter, r *http.Request,
+	change func(tx *bolt.Tx, t *Tournament, u *User) error) bool {
+	id := mux.Vars(r)["id"]
+	u := userFrom(r)
+	found := true
+	err := errLoggedOut
+	if u != nil {
+		err = db.Update(func(tx *bolt.Tx) error {
+			t := getTournament(tx, id)
+			if t == nil {
+				found = false
+				return nil
+			}
+			err := change(tx, t, u)
+			if err != nil {
+				return err
+			}
+			return putTournament(tx, t)
+		})
+	}
+	switch {
+	case !found:
+		http.NotFound(w, r)
+	case err == errLoggedOut:
+		w.WriteHeader(http.StatusUnauthorized)
+		showTournament(w, r, id, err.Error())
+	case err == errNotOrganiser:
+		w.WriteHeader(http.StatusForbidden)
+		showTournament(w, r, id, err.Error())
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		showTournament(w, r, id, err.Error())
+	default:
+		broadcastStandings(id)
+		http.Redirect(w, r, "/tournaments/"+id, http.StatusSeeOther)
+		return true
+	}
+	return false
+}
+
+// Enters the user in a tournament taking registrations
+func JoinTournament(w http.ResponseWriter,
+	r *http.Request) {
+	changeTournament(w, r, func(tx *bolt.Tx, t *Tournament, u *User) error {
+		if t.State != registering {
+			return errNotRegistering
+		}
+		if t.entrant(u.Name) < 0 {
+			t.Players = append(t.Players, Entrant{Name: u.Name})
+		}
+		return nil
+	})
+}
+
+// Takes the user out of a tournament taking registrations
+func LeaveTournament(w http.ResponseWriter,
+	r *http.Request) {
+	changeTournament(w, r, func(tx *bolt.Tx, t *Tournament, u *User) error {
+		if t.State != registering {
+			return errNotRegistering
+		}
+		if i := t.entrant(u.Name); i >= 0 {
+			t.Players = append(t.Players[:i], t.Players[i+1:]...)
+		}
+		return nil
+	})
+}
+
+// Closes registration and pairs the first round, for
+// the organiser
+func StartTournament(w http.ResponseWriter,
+	r *http.Request) {
+	changeTournament(w, r, func(tx *bolt.Tx, t *Tournament, u *User) error {
+		switch {
+		case u.Name != t.Organiser:
+			return errNotOrganiser
+		case t.State != registering:
+			return errNotRegistering
+		}
+		return startTournament(tx, t)
+	})
+}
+
+// Sets the result of an unfinished game, the form's
+// game and result, for the organiser to score forfeits
+// and adjudicate
+func TournamentResult(w http.ResponseWriter,
+	r *http.Request) {
+	var rec Record
+	ok := changeTournament(w, r, func(tx *bolt.Tx, t *Tournament, u *User) error {
+		if u.Name != t.Organiser {
+			return errNotOrganiser
+		}
+		result := r.PostFormValue("result")
+		if _, ok := resultScore(result); !ok {
+			return errBadResult
+		}
+		rec = getRecord(tx, r.PostFormValue("game"))
+		if rec.Tournament != t.Id || rec.Result != "" || t.State != playing {
+			return errNoSuchGame
+		}
+		rec.Result = result
+		err := putRecord(tx, rec)
+		if err != nil {
+			return err
+		}
+		return advanceTournament(tx, t)
+	})
+	if ok {
+		err := rateGame(rec)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// A tournament, its rounds and standings
+func TournamentShow(w http.ResponseWriter,
+	r *http.Request) {
+	showTournament(w, r, mux.Vars(r)["id"], "")
+}
+
+// JSON tournament, its rounds and standings
+func TournamentJson(w http.ResponseWriter,
+	r *http.Request) {
+	var view *TournamentView
+	err := db.View(func(tx *bolt.Tx) error {
+		view = viewTournament(tx, mux.Vars(r)["id"])
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if view == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such tournament"}`))
+		return
+	}
+	js, err := json.Marshal(view)
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.Write(js)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Bounds of a stored score
+const (
+	boundExact = iota + 1
+	boundLower // the score is at least this, it failed high
+	boundUpper // the score is at most this, it failed low
+)
+
+// mateBound is beyond any evaluation, scores past it
+// are mates and count from the ply they're found at.
+const mateBound = mateScore - 1000
+
+// defaultHashMB is the transposition table's size
+// unless configured.
+const defaultHashMB = 16
+
+// transTable is shared by all the searches.
+var transTable = NewTransTable(defaultHashMB)
+
+// TransTable remembers searched positions by Zobrist
+// key. Searches share it without locks: an entry is two
+// words, the key XOR the data and the data, so an entry
+// torn by racing writes doesn't match its key.
+type TransTable struct {
+	entries []ttSlot
+	mask    uint64
+	age     uint32 // set atomically
+}
+
+type ttSlot struct {
+	check uint64 // key ^ data
+	data  uint64
+}
+
+// ttEntry is an unpacked entry.
+type ttEntry struct {
+	move  move
+	score int
+	depth int
+	bound int
+	age   int
+}
+
+// pack lays out an entry as move:17 bound:2 depth:8
+// age:5 score:32.
+func (e ttEntry) pack() uint64 {
+	return uint64(e.move)&(1<<17-1) |
+		uint64(e.bound&3)<<17 |
+		uint64(e.depth&255)<<19 |
+		uint64(e.age&31)<<27 |
+		uint64(uint32(int32(e.score)))<<32
+}
+
+func unpack(data uint64) ttEntry {
+	return ttEntry{
+		move:  move(data & (1<<17 - 1)),
+		bound: int(data>>17) & 3,
+		depth: int(data>>19) & 255,
+		age:   int(data>>27) & 31,
+		score: int(int32(uint32(data >> 32))),
+	}
+}
+
+// NewTransTable makes a table of about mb megabytes,
+// the entries rounded down to a power of two.
+func NewTransTable(mb int) *TransTable {
+	n := uint64(1024)
+	for n*2*16 <= uint64(mb)<<20 {
+		n *= 2
+	}
+	return &TransTable{entries: make([]ttSlot, n), mask: n - 1}
+}
+
+// Size returns the table's size in megabytes.
+func (t *TransTable) Size() int {
+	return len(t.entries) * 16 >> 20
+}
+
+// Clear forgets every entry, it mustn't race searches.
+func (t *TransTable) Clear() {
+	for i := range t.entries {
+		t.entries[i] = ttSlot{}
+	}
+}
+
+// newSearch ages the entries of earlier searches, so
+// they're replaced first.
+func (t *TransTable) newSearch() {
+	atomic.AddUint32(&t.age, 1)
+}
+
+// probe returns the entry of key, if there is one.
+func (t *TransTable) probe(key uint64) (ttEntry, bool) {
+	slot := &t.entries[key&t.mask]
+	data := atomic.LoadUint64(&slot.data)
+	if atomic.LoadUint64(&slot.check)^data != key || data == 0 {
+		return ttEntry{}, false
+	}
+	return unpack(data), true
+}
+
+// store saves a search of key. An entry of another
+// position is only replaced if it's from an older
+// search or wasn't searched deeper.
+func (t *TransTable) store(key uint64, m move, score, depth, bound int) {
+	slot := &t.entries[key&t.mask]
+	age := int(atomic.LoadUint32(&t.age)) & 31
+	data := atomic.LoadUint64(&slot.data)
+	if old := unpack(data); data != 0 &&
+		atomic.LoadUint64(&slot.check)^data != key &&
+		old.age == age && old.depth > depth {
+		return
+	}
+	e := ttEntry{move: m, score: score, depth: depth, bound: bound, age: age}
+	data = e.pack()
+	atomic.StoreUint64(&slot.data, data)
+	atomic.StoreUint64(&slot.check, key^data)
+}
+
+// ttScore makes a mate score relative to the position
+// at ply, for storing.
+func ttScore(score, ply int) int {
+	switch {
+	case score > mateBound:
+		return score + ply
+	case score < -mateBound:
+		return score - ply
+	}
+	return score
+}
+
+// searchScore makes a stored mate score relative to
+// the root again.
+func searchScore(score, ply int) int {
+	switch {
+	case score > mateBound:
+		return score - ply
+	case score < -mateBound:
+		return score + ply
+	}
+	return score
+}
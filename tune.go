@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/polypmer/ghess"
+)
+
+// texelSample is a quiet position of a finished game,
+// its trace and the result for white.
+type texelSample struct {
+	index  []int16
+	coeff  []float32
+	result float64
+}
+
+// pgnGame is a game read from a PGN file.
+type pgnGame struct {
+	Fen    string // empty from the start
+	Moves  []string
+	Result string
+}
+
+// resultScore returns the score of a result for white.
+func resultScore(result string) (float64, bool) {
+	switch result {
+	case "1-0":
+		return 1, true
+	case "0-1":
+		return 0, true
+	case "1/2-1/2":
+		return 0.5, true
+	}
+	return 0, false
+}
+
+// readPgn reads the games of a PGN file, without
+// comments or variations.
+func readPgn(path string) ([]pgnGame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var games []pgnGame
+	var game pgnGame
+	var movetext []string
+	finish := func() {
+		if len(movetext) > 0 {
+			game.Moves = pgnMoves(strings.Join(movetext, " "))
+			games = append(games, game)
+		}
+		game, movetext = pgnGame{}, nil
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			if len(movetext) > 0 {
+				finish()
+			}
+			fields := strings.SplitN(strings.Trim(line, "[]"), " ", 2)
+			if len(fields) < 2 {
+				continue
+			}
+			value := strings.Trim(fields[1], `"`)
+			switch fields[0] {
+			case "Result":
+				game.Result = value
+			case "FEN":
+				game.Fen = value
+			}
+			continue
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		movetext = append(movetext, line)
+	}
+	finish()
+	return games, scanner.Err()
+}
+
+// pgnMoves returns the moves of movetext in SAN,
+// skipping comments, variations, numbers and NAGs.
+func pgnMoves(movetext string) []string {
+	var b strings.Builder
+	depth := 0
+	inComment := false
+	for _, c := range movetext {
+		switch {
+		case inComment:
+			inComment = c != '}'
+		case c == '{':
+			inComment = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	var moves []string
+	for _, tok := range strings.Fields(b.String()) {
+		if _, ok := resultScore(tok); ok || tok == "*" {
+			continue
+		}
+		// "12." or "12...Nf6"
+		if i := strings.LastIndex(tok, "."); i >= 0 {
+			tok = tok[i+1:]
+		}
+		if tok == "" || tok[0] == '$' {
+			continue // NAGs
+		}
+		moves = append(moves, tok)
+	}
+	return moves
+}
+
+// texelSet collects the samples of games.
+type texelSet struct {
+	samples []texelSample
+	skip    int // opening plies left out
+	w       *Weights
+	s       *searcher // for quiescence
+	trace   []float64
+}
+
+// add samples p, ply plies into a game, if it's quiet:
+// not in check, with no capture changing the score.
+func (t *texelSet) add(p *Position, ply int, result float64) {
+	if ply < t.skip || p.inCheck() {
+		return
+	}
+	sign := 1
+	if p.side == Black {
+		sign = -1
+	}
+	if t.s.quiesce(p, 0, -mateScore-1, mateScore+1) != sign*p.evaluate(t.w) {
+		return
+	}
+	for i := range t.trace {
+		t.trace[i] = 0
+	}
+	p.score(t.w, t.trace)
+	sample := texelSample{result: result}
+	for i, c := range t.trace {
+		if c != 0 {
+			sample.index = append(sample.index, int16(i))
+			sample.coeff = append(sample.coeff, float32(c))
+		}
+	}
+	t.samples = append(t.samples, sample)
+}
+
+// addRecords samples the finished games of a growser
+// database, read only so the server may keep it open.
+func (t *texelSet) addRecords(path string) (int, error) {
+	store, err := bolt.Open(path, 0644, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+	var recs []Record
+	err = store.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(records)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var rec Record
+			if json.Unmarshal(v, &rec) == nil && rec.Result != "" {
+				recs = append(recs, rec)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	games := 0
+	for _, rec := range recs {
+		result, ok := resultScore(rec.Result)
+		if !ok {
+			continue
+		}
+		game := ghess.NewBoard()
+		if rec.Start != "" {
+			if game.LoadFen(rec.Start) != nil {
+				continue
+			}
+		}
+		p, err := positionOf(&game)
+		if err != nil {
+			continue
+		}
+		for ply, mv := range rec.Moves {
+			orig, dest, err := parseMoveString(mv)
+			if err != nil {
+				break
+			}
+			m, err := p.moveOf(orig, dest)
+			if err != nil {
+				break
+			}
+			p.makeMove(m)
+			t.add(p, ply+1, result)
+		}
+		games++
+	}
+	return games, nil
+}
+
+// addPgn samples the finished games of a PGN file.
+func (t *texelSet) addPgn(path string) (int, error) {
+	pgns, err := readPgn(path)
+	if err != nil {
+		return 0, err
+	}
+	games := 0
+	for _, g := range pgns {
+		result, ok := resultScore(g.Result)
+		if !ok {
+			continue
+		}
+		fen := g.Fen
+		if fen == "" {
+			fen = startFen
+		}
+		p, err := ParseFen(fen)
+		if err != nil {
+			continue
+		}
+		for ply, mv := range g.Moves {
+			m, err := p.parseSan(mv)
+			if err != nil {
+				break
+			}
+			p.makeMove(m)
+			t.add(p, ply+1, result)
+		}
+		games++
+	}
+	return games, nil
+}
+
+// sigmoid turns a score for white into an expected result.
+func sigmoid(k, score float64) float64 {
+	return 1 / (1 + math.Pow(10, -k*score/400))
+}
+
+// texelError is the mean squared error of the
+// expected results of samples with weights params.
+func texelError(samples []texelSample, params []float64, k float64) float64 {
+	sum := 0.0
+	for _, s := range samples {
+		d := s.result - sigmoid(k, s.eval(params))
+		sum += d * d
+	}
+	return sum / float64(len(samples))
+}
+
+// eval is the evaluation of the sample with params.
+func (s texelSample) eval(params []float64) float64 {
+	e := 0.0
+	for i, idx := range s.index {
+		e += float64(s.coeff[i]) * params[idx]
+	}
+	return e
+}
+
+// bestK finds the scaling of scores to results which
+// fits the samples best, by narrowing a scan.
+func bestK(samples []texelSample, params []float64) float64 {
+	lo, hi := 0.0, 3.0
+	best := 1.0
+	for round := 0; round < 4; round++ {
+		step := (hi - lo) / 10
+		bestErr := math.Inf(1)
+		for k := lo; k <= hi; k += step {
+			if e := texelError(samples, params, k); e < bestErr {
+				best, bestErr = k, e
+			}
+		}
+		lo, hi = math.Max(best-step, 0.01), best+step
+	}
+	return best
+}
+
+// fit runs Adam over the mean squared error, Texel
+// tuning as logistic regression, and reports progress.
+func fit(samples []texelSample, params []float64, k, rate float64, iterations int) {
+	const beta1, beta2, epsilon = 0.9, 0.999, 1e-8
+	m := make([]float64, len(params))
+	v := make([]float64, len(params))
+	grad := make([]float64, len(params))
+	c := k * math.Ln10 / 400
+	for it := 1; it <= iterations; it++ {
+		for i := range grad {
+			grad[i] = 0
+		}
+		for _, s := range samples {
+			sg := sigmoid(k, s.eval(params))
+			g := -2 * (s.result - sg) * sg * (1 - sg) * c
+			for i, idx := range s.index {
+				grad[idx] += g * float64(s.coeff[i])
+			}
+		}
+		for i := range params {
+			g := grad[i] / float64(len(samples))
+			m[i] = beta1*m[i] + (1-beta1)*g
+			v[i] = beta2*v[i] + (1-beta2)*g*g
+			mHat := m[i] / (1 - math.Pow(beta1, float64(it)))
+			vHat := v[i] / (1 - math.Pow(beta2, float64(it)))
+			params[i] -= rate * mHat / (math.Sqrt(vHat) + epsilon)
+		}
+		if it%50 == 0 || it == iterations {
+			fmt.Printf("iteration %d error %.6f\n", it, texelError(samples, params, k))
+		}
+	}
+}
+
+// TuneCommand runs "growser tune", fitting the weights
+// of the evaluation to the results of finished games.
+func TuneCommand(args []string) {
+	flags := flag.NewFlagSet("tune", flag.ExitOnError)
+	dbPath := flags.String("db", "", "growser database to take finished games from, eg games.db")
+	pgnPath := flags.String("pgn", "", "PGN file to take finished games from")
+	start := flags.String("weights", "", "weights to start from, growser's without")
+	out := flags.String("out", "weights.json", "file the weights are written to")
+	iterations := flags.Int("iterations", 500, "passes over the positions")
+	rate := flags.Float64("rate", 1, "learning rate, in centipawns")
+	skip := flags.Int("skip", 8, "opening plies of each game left out")
+	flags.Parse(args)
+	if *dbPath == "" && *pgnPath == "" {
+		fmt.Println("growser tune needs -db or -pgn")
+		os.Exit(2)
+	}
+
+	w := defaultWeights()
+	if *start != "" {
+		var err error
+		w, err = LoadWeights(*start)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	t := &texelSet{skip: *skip, w: w, trace: make([]float64, numWeights),
+		s: &searcher{weights: w}}
+	if *dbPath != "" {
+		games, err := t.addRecords(*dbPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d games from %s\n", games, *dbPath)
+	}
+	if *pgnPath != "" {
+		games, err := t.addPgn(*pgnPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d games from %s\n", games, *pgnPath)
+	}
+	if len(t.samples) < 1 {
+		fmt.Println("No quiet positions to tune with")
+		os.Exit(1)
+	}
+
+	ps := w.params()
+	params := make([]float64, len(ps))
+	for i, p := range ps {
+		params[i] = float64(*p)
+	}
+	k := bestK(t.samples, params)
+	fmt.Printf("%d positions, K %.3f, error %.6f\n", len(t.samples), k,
+		texelError(t.samples, params, k))
+	fit(t.samples, params, k, *rate, *iterations)
+	for i, p := range ps {
+		*p = int(math.Round(params[i]))
+	}
+	err := SaveWeights(*out, w)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Weights written to", *out)
+}
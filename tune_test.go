@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPgnMoves(t *testing.T) {
+	cases := []struct {
+		movetext string
+		want     []string
+	}{
+		{"1. e4 e5 2. Nf3 1-0", []string{"e4", "e5", "Nf3"}},
+		{"1. e4 {best by test} e5 $1 2. Nf3 (2. f4 exf4) 2...Nc6 *",
+			[]string{"e4", "e5", "Nf3", "Nc6"}},
+		{"1. d4 (1. e4 (1. c4) e5) 1... d5 1/2-1/2", []string{"d4", "d5"}},
+	}
+	for _, c := range cases {
+		if got := pgnMoves(c.movetext); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.movetext, got, c.want)
+		}
+	}
+}
+
+const testPgn = `[Event "one"]
+[Result "1-0"]
+
+1. e4 e5 2. Qh5 Nc6 3. Bc4 Nf6 ; hopeful
+4. Qxf7# 1-0
+
+[Event "two"]
+[FEN "8/8/8/8/8/8/4k3/4K3 w - - 0 1"]
+[Result "1/2-1/2"]
+
+1. Kf1 Kd2 1/2-1/2
+`
+
+func TestReadPgn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "growser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "games.pgn")
+	if err := ioutil.WriteFile(path, []byte(testPgn), 0600); err != nil {
+		t.Fatal(err)
+	}
+	games, err := readPgn(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pgnGame{
+		{Moves: []string{"e4", "e5", "Qh5", "Nc6", "Bc4", "Nf6", "Qxf7#"}, Result: "1-0"},
+		{Fen: "8/8/8/8/8/8/4k3/4K3 w - - 0 1", Moves: []string{"Kf1", "Kd2"}, Result: "1/2-1/2"},
+	}
+	if !reflect.DeepEqual(games, want) {
+		t.Errorf("%+v, want %+v", games, want)
+	}
+	w := defaultWeights()
+	set := &texelSet{w: w, s: &searcher{weights: w}, trace: make([]float64, numWeights)}
+	if n, err := set.addPgn(path); err != nil || n != 2 {
+		t.Errorf("sampled %d games, %v", n, err)
+	}
+	if len(set.samples) < 1 {
+		t.Error("no quiet positions")
+	}
+}
+
+// The evaluation is the trace times the weights, up
+// to the rounding of the phase.
+func TestScoreTrace(t *testing.T) {
+	w := defaultWeights()
+	params := w.params()
+	if len(params) != numWeights {
+		t.Fatalf("%d params, want %d", len(params), numWeights)
+	}
+	for _, pos := range benchPositions {
+		p, err := ParseFen(pos.Fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trace := make([]float64, numWeights)
+		score := p.score(w, trace)
+		sum := 0.0
+		for i, c := range trace {
+			sum += c * float64(*params[i])
+		}
+		if math.Abs(sum-float64(score)) > 1 {
+			t.Errorf("%s: score %d, trace %.2f", pos.Name, score, sum)
+		}
+	}
+}
+
+func TestWeightsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "growser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := defaultWeights()
+	w.BishopPair = 45
+	w.PassedPawn[6] = 120
+	path := filepath.Join(dir, "weights.json")
+	if err := SaveWeights(path, w); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadWeights(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, w) {
+		t.Error("weights changed through the file")
+	}
+	// Left out weights keep their default
+	ioutil.WriteFile(path, []byte(`{"bishopPair": 50}`), 0600)
+	got, err = LoadWeights(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BishopPair != 50 || got.Material != defaultWeights().Material {
+		t.Errorf("partial weights %d %v", got.BishopPair, got.Material)
+	}
+}
+
+// Samples of one weight, won when it counts for
+// white and lost when for black, fit a positive weight.
+func TestFit(t *testing.T) {
+	var samples []texelSample
+	for i := 1; i <= 10; i++ {
+		samples = append(samples,
+			texelSample{index: []int16{0}, coeff: []float32{float32(i)}, result: 1},
+			texelSample{index: []int16{0}, coeff: []float32{float32(-i)}, result: 0})
+	}
+	params := []float64{0}
+	before := texelError(samples, params, 1)
+	fit(samples, params, 1, 10, 100)
+	if params[0] <= 0 {
+		t.Errorf("weight %f, want positive", params[0])
+	}
+	if after := texelError(samples, params, 1); after >= before {
+		t.Errorf("error %f after fitting, %f before", after, before)
+	}
+	if k := bestK(samples, []float64{100}); k < 2 {
+		t.Errorf("k %f for decisive samples", k)
+	}
+}
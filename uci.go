@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// uciDefaultStrength is the strength of a new UCI
+// session, Medium in computer.html.
+const uciDefaultStrength = 4
+
+// uciSession is growser speaking UCI with a GUI.
+type uciSession struct {
+	out      io.Writer
+	mu       sync.Mutex // guards out
+	game     ghess.Board
+	ply      int // plies since the start, for the book
+	strength int
+	threads  int
+	ownBook  bool
+
+	stopCh chan struct{} // closed to stop the search
+	wg     sync.WaitGroup
+}
+
+// UciCommand runs "growser uci", speaking UCI on
+// stdin and stdout until quit.
+func UciCommand(args []string) {
+	u := &uciSession{
+		out:      os.Stdout,
+		game:     ghess.NewBoard(),
+		strength: uciDefaultStrength,
+		threads:  1,
+		ownBook:  true,
+	}
+	u.run(os.Stdin)
+}
+
+// send writes a line to the GUI.
+func (u *uciSession) send(format string, a ...interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fmt.Fprintf(u.out, format+"\n", a...)
+}
+
+// run reads commands until quit or the end of in.
+func (u *uciSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 1 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			u.send("id name growser")
+			u.send("id author Fenimore Love")
+			u.send("option name Strength type spin default %d min 1 max 8",
+				uciDefaultStrength)
+			u.send("option name Hash type spin default %d min 1 max 4096",
+				defaultHashMB)
+			u.send("option name Threads type spin default 1 min 1 max %d",
+				maxThreads)
+			u.send("option name Weights type string default <empty>")
+			u.send("option name OwnBook type check default true")
+			u.send("option name Book type string default <empty>")
+			u.send("uciok")
+		case "isready":
+			u.send("readyok")
+		case "ucinewgame":
+			u.stop()
+			u.game = ghess.NewBoard()
+			u.ply = 0
+			transTable.Clear()
+		case "setoption":
+			u.setOption(fields[1:])
+		case "position":
+			u.stop()
+			err := u.position(fields[1:])
+			if err != nil {
+				u.send("info string %s", err)
+			}
+		case "go":
+			u.stop()
+			u.goSearch(fields[1:])
+		case "stop":
+			u.stop()
+		case "quit":
+			u.stop()
+			return
+		}
+	}
+	u.stop()
+}
+
+// setOption reads "name <name> value <value>".
+func (u *uciSession) setOption(args []string) {
+	var name, value []string
+	var inValue bool
+	for _, a := range args {
+		switch {
+		case a == "name":
+		case a == "value":
+			inValue = true
+		case inValue:
+			value = append(value, a)
+		default:
+			name = append(name, a)
+		}
+	}
+	v := strings.Join(value, " ")
+	switch strings.ToLower(strings.Join(name, " ")) {
+	case "strength":
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			u.send("info string Invalid strength %s", v)
+			return
+		}
+		u.strength = n
+	case "hash":
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			u.send("info string Invalid hash %s", v)
+			return
+		}
+		transTable = NewTransTable(n)
+	case "threads":
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxThreads {
+			u.send("info string Invalid threads %s", v)
+			return
+		}
+		u.threads = n
+	case "weights":
+		if v == "" || v == "<empty>" {
+			evalWeights = defaultWeights()
+			return
+		}
+		w, err := LoadWeights(v)
+		if err != nil {
+			u.send("info string %s", err)
+			return
+		}
+		evalWeights = w
+	case "ownbook":
+		u.ownBook = v == "true"
+	case "book":
+		if v == "" || v == "<empty>" {
+			books = nil
+			return
+		}
+		b, err := LoadBook(v)
+		if err != nil {
+			u.send("info string %s", err)
+			return
+		}
+		books = []*Book{b}
+	default:
+		u.send("info string Unknown option %s", strings.Join(name, " "))
+	}
+}
+
+// position reads "startpos" or "fen <fen>", then "moves ...".
+func (u *uciSession) position(args []string) error {
+	game := ghess.NewBoard()
+	ply := 0
+	i := 0
+	switch {
+	case len(args) > 0 && args[0] == "startpos":
+		i = 1
+	case len(args) > 0 && args[0] == "fen":
+		i = 1
+		for i < len(args) && args[i] != "moves" {
+			i++
+		}
+		fen := strings.Join(args[1:i], " ")
+		var err error
+		game, err = loadFen(fen)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(fen)
+		if len(fields) > 5 {
+			n, _ := strconv.Atoi(fields[5])
+			ply = 2 * (n - 1)
+		}
+		if turnOf(&game) == "b" {
+			ply++
+		}
+	}
+	if i < len(args) && args[i] == "moves" {
+		for _, mv := range args[i+1:] {
+			orig, dest, err := parseUciMove(&game, mv)
+			if err != nil {
+				return err
+			}
+			err = game.Move(orig, dest)
+			if err != nil {
+				return fmt.Errorf("%s: %s", mv, err)
+			}
+			ply++
+		}
+	}
+	u.game, u.ply = game, ply
+	return nil
+}
+
+// moveTime decides how long to think from the go
+// arguments, 0 for no limit.
+func moveTime(goArgs map[string]int, white bool) time.Duration {
+	if mt, ok := goArgs["movetime"]; ok {
+		return time.Duration(mt) * time.Millisecond
+	}
+	left, inc := goArgs["btime"], goArgs["binc"]
+	if white {
+		left, inc = goArgs["wtime"], goArgs["winc"]
+	}
+	if left <= 0 {
+		return 0
+	}
+	togo := goArgs["movestogo"]
+	if togo <= 0 {
+		togo = 30
+	}
+	ms := left/togo + inc/2
+	// Keep something for the moves after this one
+	if ms > left/2 {
+		ms = left / 2
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// goSearch starts a search in the background, it
+// sends bestmove when done or stopped.
+func (u *uciSession) goSearch(args []string) {
+	goArgs := make(map[string]int)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "infinite", "ponder":
+			goArgs[args[i]] = 1
+		default:
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err == nil {
+					goArgs[args[i]] = n
+					i++
+				}
+			}
+		}
+	}
+	game := u.game
+	white := turnOf(&game) == "w"
+	_, infinite := goArgs["infinite"]
+
+	// The strength bounds the depth, like the
+	// difficulty does in computer.html.
+	l := Limits{Depth: u.strength, Threads: u.threads}
+	if d, ok := goArgs["depth"]; ok {
+		l.Depth = d
+	}
+	if infinite {
+		l.Depth = maxDepth
+	} else {
+		l.MoveTime = moveTime(goArgs, white)
+	}
+	l.Info = func(i Info) {
+		u.send("info depth %d score %s nodes %d time %d pv %s",
+			i.Depth, uciScore(i.Score), i.Nodes,
+			i.Time/time.Millisecond, uciPv(&game, i.Pv))
+	}
+	stopCh := make(chan struct{})
+	l.Stop = stopCh
+	u.stopCh = stopCh
+	ply, strength, ownBook := u.ply, u.strength, u.ownBook
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		var res Result
+		var book bool
+		var err error
+		if ownBook {
+			res, book, err = thinkMove(&game, ply, strength, l)
+		} else {
+			res, err = searchMove(&game, strength, l)
+		}
+		if err != nil {
+			u.send("info string %s", err)
+		}
+		if book {
+			u.send("info string book move")
+		}
+		// An infinite search waits for stop to answer
+		if infinite {
+			<-stopCh
+		}
+		if res.Orig == 0 {
+			u.send("bestmove 0000")
+			return
+		}
+		u.send("bestmove %s", uciMove(&game, res.Orig, res.Dest))
+	}()
+}
+
+// stop ends a running search, which sends its bestmove.
+func (u *uciSession) stop() {
+	if u.stopCh != nil {
+		close(u.stopCh)
+	}
+	u.wg.Wait()
+	u.stopCh = nil
+}
+
+// uciScore writes a score as "cp n" or "mate n".
+func uciScore(score int) string {
+	switch {
+	case score > mateBound:
+		return fmt.Sprintf("mate %d", (mateScore-score+1)/2)
+	case score < -mateBound:
+		return fmt.Sprintf("mate %d", -(mateScore+score+1)/2)
+	}
+	return fmt.Sprintf("cp %d", score)
+}
+
+// uciPv writes a principal variation from g.
+func uciPv(g *ghess.Board, pv [][2]int) string {
+	b := ghess.CopyBoard(g)
+	moves := make([]string, 0, len(pv))
+	for _, mv := range pv {
+		moves = append(moves, uciMove(b, mv[0], mv[1]))
+		if b.Move(mv[0], mv[1]) != nil {
+			break
+		}
+	}
+	return strings.Join(moves, " ")
+}
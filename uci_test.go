@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// engineIo talks to a UCI or XBoard session
+// through pipes, as a GUI would.
+type engineIo struct {
+	t     *testing.T
+	send  *io.PipeWriter
+	lines chan string
+}
+
+// startEngineIo points out at a pipe and runs run
+// in the background, reading what the test sends.
+func startEngineIo(t *testing.T, out *io.Writer, run func(io.Reader)) *engineIo {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	*out = outW
+	e := &engineIo{t: t, send: inW, lines: make(chan string, 10000)}
+	go func() {
+		run(inR)
+		outW.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			e.lines <- scanner.Text()
+		}
+		close(e.lines)
+	}()
+	return e
+}
+
+// expect sends cmd, unless empty, and returns the
+// first line the session writes starting with prefix.
+func (e *engineIo) expect(cmd, prefix string) string {
+	e.t.Helper()
+	if cmd != "" {
+		fmt.Fprintln(e.send, cmd)
+	}
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				e.t.Fatalf("%s: session ended before %q", cmd, prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			e.t.Fatalf("%s: no %q", cmd, prefix)
+		}
+	}
+}
+
+// say sends cmd, which has no answer.
+func (e *engineIo) say(cmd string) {
+	fmt.Fprintln(e.send, cmd)
+}
+
+// quit ends the session and waits for it.
+func (e *engineIo) quit() {
+	fmt.Fprintln(e.send, "quit")
+	for range e.lines {
+	}
+}
+
+func TestUciSession(t *testing.T) {
+	u := &uciSession{strength: uciDefaultStrength}
+	e := startEngineIo(t, &u.out, u.run)
+	defer e.quit()
+	e.expect("uci", "uciok")
+	e.say("setoption name OwnBook value false")
+	e.say("setoption name Threads value 1")
+	e.expect("isready", "readyok")
+	if line := e.expect("setoption name Strength value x", "info string"); !strings.Contains(line, "Invalid strength") {
+		t.Errorf("bad strength: %s", line)
+	}
+
+	// Scholar's mate
+	e.say("position fen r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4")
+	if line := e.expect("go depth 3", "bestmove"); line != "bestmove h5f7" {
+		t.Errorf("mate in one: %s", line)
+	}
+	e.say("position startpos moves e2e4 e7e5 g1f3")
+	line := e.expect("go depth 2", "bestmove")
+	if move := strings.TrimPrefix(line, "bestmove "); len(move) != 4 || move[1] != '7' && move[1] != '8' {
+		t.Errorf("black to move played %s", line)
+	}
+	e.expect("position startpos moves e2e5", "info string")
+}
+
+func TestUciScore(t *testing.T) {
+	cases := []struct {
+		score int
+		want  string
+	}{
+		{35, "cp 35"},
+		{-120, "cp -120"},
+		{mateScore - 1, "mate 1"},
+		{mateScore - 3, "mate 2"},
+		{-mateScore + 2, "mate -1"},
+	}
+	for _, c := range cases {
+		if got := uciScore(c.score); got != c.want {
+			t.Errorf("%d: %s, want %s", c.score, got, c.want)
+		}
+	}
+}
+
+func TestMoveTime(t *testing.T) {
+	cases := []struct {
+		args  map[string]int
+		white bool
+		want  time.Duration
+	}{
+		{map[string]int{"movetime": 500}, true, 500 * time.Millisecond},
+		{map[string]int{}, true, 0},
+		{map[string]int{"wtime": 60000, "btime": 1000}, true, 2 * time.Second},
+		{map[string]int{"wtime": 60000, "btime": 1000}, false, 33 * time.Millisecond},
+		{map[string]int{"wtime": 10000, "winc": 2000, "movestogo": 5}, true, 3 * time.Second},
+		{map[string]int{"wtime": 1000, "movestogo": 1}, true, 500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if got := moveTime(c.args, c.white); got != c.want {
+			t.Errorf("%v %v: %s, want %s", c.args, c.white, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// uciTimeout is how long a UCI engine may take to answer
+// beyond the time it was given, before it's killed.
+const uciTimeout = 10 * time.Second
+
+// UCIEngine plays with a local UCI engine binary.
+// Processes are started as they're needed, up to Size,
+// and shared across games: a search takes an idle
+// process and gives it back when it's done.
+type UCIEngine struct {
+	Path string
+	Args []string
+	Size int // most processes at once
+
+	slots  chan struct{} // taken by busy processes
+	idle   chan *uciProcess
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewUCIEngine returns an UCIEngine of path, running at
+// most size processes.
+func NewUCIEngine(path string, size int, args ...string) *UCIEngine {
+	if size < 1 {
+		size = 1
+	}
+	return &UCIEngine{
+		Path:  path,
+		Args:  args,
+		Size:  size,
+		slots: make(chan struct{}, size),
+		idle:  make(chan *uciProcess, size),
+	}
+}
+
+// uciProcess is a running UCI engine.
+type uciProcess struct {
+	cmd   *exec.Cmd
+	in    io.WriteCloser
+	lines chan string // closed when the engine exits
+}
+
+// startUci launches the engine and waits for uciok and readyok.
+func startUci(path string, args []string) (*uciProcess, error) {
+	cmd := exec.Command(path, args...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	p := &uciProcess{cmd: cmd, in: in, lines: make(chan string, 64)}
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			p.lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(p.lines)
+	}()
+	if err = p.send("uci"); err == nil {
+		_, err = p.expect("uciok", uciTimeout)
+	}
+	if err == nil {
+		err = p.ready()
+	}
+	if err != nil {
+		p.kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+// send writes a command line to the engine.
+func (p *uciProcess) send(cmd string) error {
+	_, err := io.WriteString(p.in, cmd+"\n")
+	return err
+}
+
+// expect reads lines until one starts with prefix,
+// and returns the lines read, that one last.
+func (p *uciProcess) expect(prefix string, timeout time.Duration) ([]string, error) {
+	var lines []string
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return lines, errors.New("UCI engine exited")
+			}
+			lines = append(lines, line)
+			if strings.HasPrefix(line, prefix) {
+				return lines, nil
+			}
+		case <-deadline:
+			return lines, errors.New("UCI engine timed out waiting for " + prefix)
+		}
+	}
+}
+
+// ready syncs with the engine, isready and readyok.
+func (p *uciProcess) ready() error {
+	err := p.send("isready")
+	if err != nil {
+		return err
+	}
+	_, err = p.expect("readyok", uciTimeout)
+	return err
+}
+
+// kill stops the engine, politely first.
+func (p *uciProcess) kill() {
+	p.send("quit")
+	// Nobody waits for its lines anymore
+	go func() {
+		for range p.lines {
+		}
+	}()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// get waits for a free slot, then takes an idle process
+// or starts one, so there are never more than Size.
+func (e *UCIEngine) get() (*uciProcess, error) {
+	e.slots <- struct{}{}
+	e.mu.Lock()
+	closed := e.closed
+	e.mu.Unlock()
+	if closed {
+		<-e.slots
+		return nil, errors.New("UCI engine closed")
+	}
+	select {
+	case p := <-e.idle:
+		return p, nil
+	default:
+	}
+	p, err := startUci(e.Path, e.Args)
+	if err != nil {
+		<-e.slots
+	}
+	return p, err
+}
+
+// put gives a process back, or drops it if it broke.
+func (e *UCIEngine) put(p *uciProcess, broken bool) {
+	e.mu.Lock()
+	closed := e.closed
+	e.mu.Unlock()
+	if broken || closed {
+		go p.kill()
+	} else {
+		e.idle <- p
+	}
+	<-e.slots
+}
+
+// BestMove sends the position and a go command, and
+// reads info lines for the score until bestmove.
+func (e *UCIEngine) BestMove(g *ghess.Board, l Limits) (Result, error) {
+	res := Result{}
+	p, err := e.get()
+	if err != nil {
+		return res, err
+	}
+	broken := true
+	defer func() { e.put(p, broken) }()
+
+	if err = p.send("ucinewgame"); err != nil {
+		return res, err
+	}
+	if err = p.ready(); err != nil {
+		return res, err
+	}
+	if err = p.send("position fen " + standardFen(g)); err != nil {
+		return res, err
+	}
+	goCmd := "go depth " + strconv.Itoa(l.Depth)
+	wait := uciTimeout
+	if l.MoveTime > 0 {
+		goCmd = fmt.Sprintf("go movetime %d", l.MoveTime/time.Millisecond)
+		wait += l.MoveTime
+	}
+	if err = p.send(goCmd); err != nil {
+		return res, err
+	}
+	lines, err := p.expect("bestmove", wait)
+	if err != nil {
+		// Ask it to stop, and give it one more chance
+		p.send("stop")
+		more, err := p.expect("bestmove", time.Second)
+		if err != nil {
+			return res, err
+		}
+		lines = append(lines, more...)
+	}
+	for _, line := range lines {
+		if score, ok := parseUciScore(line); ok {
+			res.Score = score
+		}
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	broken = false
+	if len(fields) < 2 || fields[1] == "(none)" || fields[1] == "0000" {
+		return res, nil
+	}
+	res.Orig, res.Dest, err = parseUciMove(g, fields[1])
+	return res, err
+}
+
+// parseUciScore reads the score of an info line, mates
+// are scored like ghess scores them.
+func parseUciScore(line string) (int, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 1 || fields[0] != "info" {
+		return 0, false
+	}
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "score" {
+			continue
+		}
+		n, err := strconv.Atoi(fields[i+2])
+		if err != nil {
+			return 0, false
+		}
+		switch fields[i+1] {
+		case "cp":
+			return n, true
+		case "mate":
+			// Mate in 0 is the player to move mated
+			if n <= 0 {
+				return -mateScore, true
+			}
+			return mateScore, true
+		}
+	}
+	return 0, false
+}
+
+// Close quits the idle processes, busy ones quit
+// when they're given back.
+func (e *UCIEngine) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	for {
+		select {
+		case p := <-e.idle:
+			p.kill()
+		default:
+			return nil
+		}
+	}
+}
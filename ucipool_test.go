@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/polypmer/ghess"
+)
+
+// buildFakeUci builds the fakeuci helper for a test.
+func buildFakeUci(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "fakeuci")
+	out, err := exec.Command("go", "build", "-o", bin, "./fakeuci").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building fakeuci: %s\n%s", err, out)
+	}
+	return bin
+}
+
+// legal returns true if orig to dest is a legal move of g.
+func legal(g *ghess.Board, orig, dest int) bool {
+	next := ghess.CopyBoard(g)
+	return orig != 0 && next.Move(orig, dest) == nil
+}
+
+func TestUCIEngineBestMove(t *testing.T) {
+	e := NewUCIEngine(buildFakeUci(t), 2)
+	defer e.Close()
+	for _, fen := range []string{startFen,
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1"} {
+		game, err := loadFen(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := e.BestMove(&game, Limits{Depth: 1})
+		if err != nil {
+			t.Fatalf("%s: %s", fen, err)
+		}
+		if !legal(&game, res.Orig, res.Dest) {
+			t.Errorf("%s: illegal move %d %d", fen, res.Orig, res.Dest)
+		}
+	}
+}
+
+func TestUCIEngineMated(t *testing.T) {
+	e := NewUCIEngine(buildFakeUci(t), 1)
+	defer e.Close()
+	// Fool's mate, white to move and mated
+	game, err := loadFen("rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.BestMove(&game, Limits{Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Orig != 0 || res.Score != -mateScore {
+		t.Errorf("got %+v, want no move and mated", res)
+	}
+}
+
+func TestUCIEnginePool(t *testing.T) {
+	const size = 2
+	e := NewUCIEngine(buildFakeUci(t), size)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			game := ghess.NewBoard()
+			res, err := e.BestMove(&game, Limits{Depth: 1})
+			if err == nil && !legal(&game, res.Orig, res.Dest) {
+				t.Errorf("illegal move %d %d", res.Orig, res.Dest)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := len(e.idle); n > size {
+		t.Errorf("%d idle processes, at most %d", n, size)
+	}
+	e.Close()
+	game := ghess.NewBoard()
+	if _, err := e.BestMove(&game, Limits{Depth: 1}); err == nil {
+		t.Error("a closed engine played a move")
+	}
+}
+
+func TestParseUciScore(t *testing.T) {
+	cases := []struct {
+		line  string
+		score int
+		ok    bool
+	}{
+		{"info depth 5 score cp 31 nodes 100 pv e2e4", 31, true},
+		{"info depth 5 score cp -120 pv e2e4", -120, true},
+		{"info depth 9 score mate 3 pv h5f7", mateScore, true},
+		{"info depth 1 score mate -2", -mateScore, true},
+		{"info depth 0 score mate 0", -mateScore, true},
+		{"info string hello", 0, false},
+		{"bestmove e2e4", 0, false},
+	}
+	for _, c := range cases {
+		score, ok := parseUciScore(c.line)
+		if score != c.score || ok != c.ok {
+			t.Errorf("%q: got %d %v, want %d %v", c.line, score, ok, c.score, c.ok)
+		}
+	}
+}
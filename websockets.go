@@ -58,6 +58,16 @@ type Hub struct {
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Replies to one client, like legal moves.
+	direct chan Reply
+}
+
+// Reply is a message for one client only. It goes
+// through the hub, which closes a client's send.
+type Reply struct {
+	client  *Client
+	message []byte
 }
 
 // newHub returns a pointer to a new Hub
@@ -66,6 +76,7 @@ func newHub() *Hub {
 		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		direct:     make(chan Reply),
 		clients:    make(map[*Client]bool),
 	}
 }
@@ -82,6 +93,19 @@ func (h *Hub) run() {
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
+				if !h.watching(client.game) {
+					stopPonder(client.game)
+				}
+			}
+		case r := <-h.direct:
+			if _, ok := h.clients[r.client]; !ok {
+				continue
+			}
+			select {
+			case r.client.send <- r.message:
+			default:
+				close(r.client.send)
+				delete(h.clients, r.client)
 			}
 		case message := <-h.broadcast:
 			for client := range h.clients {
@@ -96,6 +120,16 @@ func (h *Hub) run() {
 	}
 }
 
+// watching is whether a client is still on game.
+func (h *Hub) watching(game string) bool {
+	for client := range h.clients {
+		if client.game == game {
+			return true
+		}
+	}
+	return false
+}
+
 // This is the json passed from
 // the javascript websockets front end
 // It's type dictates what kind of broadcast
@@ -117,6 +151,8 @@ type outGo struct {
 	Position string
 	Message  string
 	Error    string
+	Origin   string
+	Targets  []string
 }
 
 /* Client Functions */
@@ -129,6 +165,12 @@ type Client struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// The seat of the player, see seatOf.
+	user string
+
+	// The id of the game, moves of others are ignored.
+	game string
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -153,10 +195,67 @@ func (c *Client) readPump() {
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
+		// Legal move lookups only concern the
+		// player who asked, so don't broadcast them.
+		msg := inCome{}
+		json.Unmarshal(message, &msg)
+		if msg.Type == "moves" {
+			c.hub.direct <- Reply{c, message}
+			continue
+		}
+		if msg.Type == "move" {
+			ok, err := claimSeat(msg.Id, c.user, msg.Origin, msg.Destination)
+			if err != nil {
+				fmt.Println(err)
+			}
+			if !ok {
+				c.hub.direct <- Reply{c, []byte(`{"type":"refused","message":"This isn't your move"}`)}
+				continue
+			}
+		}
 		c.hub.broadcast <- message
 	}
 }
 
+// claimSeat returns true if user may play orig to dest
+// in the challenge of id. Anybody may take the empty
+// seat of the side to move with a legal move, but once
+// it's taken only its player moves for that side, and
+// nobody once the game has a result.
+func claimSeat(id, user, orig, dest string) (bool, error) {
+	ok := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("challenges"))
+		if bucket == nil {
+			return nil
+		}
+		g := ghess.NewBoard()
+		err := g.LoadFen(string(bucket.Get([]byte(id))))
+		if err != nil {
+			return err
+		}
+		rec := getRecord(tx, id)
+		if rec.Result != "" {
+			return nil
+		}
+		seat := &rec.White
+		if turnOf(&g) == "b" {
+			seat = &rec.Black
+		}
+		if *seat != "" {
+			ok = *seat == user
+			return nil
+		}
+		ok = true
+		if user == "" || rec.Kind != "challenge" || g.ParseStand(orig, dest) != nil {
+			return nil
+		}
+		*seat = user
+		return putRecord(tx, rec)
+	})
+	return ok, err
+}
+
 // write writes a message with the given message type and payload.
 func (c *Client) write(mt int, payload []byte) error {
 	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
@@ -180,15 +279,18 @@ func (c *Client) writePump(g ghess.Board) {
 				return
 			}
 
+			// read json from message
+			msg := inCome{}
+			json.Unmarshal([]byte(message), &msg)
+			if msg.Type == "move" && msg.Id != c.game {
+				continue
+			}
+
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
-
-			// read json from message
-			msg := inCome{}
-			json.Unmarshal([]byte(message), &msg)
 			switch msg.Type {
 			case "move":
 				mv := &outGo{}
@@ -220,9 +322,27 @@ func (c *Client) writePump(g ghess.Board) {
 				feedback = ""
 				// Marshal into json response
 				j, _ := json.Marshal(mv)
-				// Update the DB
+				// Update the DB, every client plays the move
+				// on its own board, so only the first one
+				// to change the position records it.
+				var over bool
+				var rec Record
+				moveErr := err
 				err := db.Update(func(tx *bolt.Tx) error {
 					bucket := tx.Bucket([]byte("challenges"))
+					if moveErr == nil &&
+						string(bucket.Get([]byte(msg.Id))) != fen {
+						rec = getRecord(tx, msg.Id)
+						rec.Moves = append(rec.Moves, moveString(
+							ghess.PgnToCoordMap[msg.Origin],
+							ghess.PgnToCoordMap[msg.Destination]))
+						rec.Result = gameResult(&g)
+						over = rec.Result != ""
+						err = putRecord(tx, rec)
+						if err != nil {
+							return err
+						}
+					}
 					err = bucket.Put([]byte(msg.Id), []byte(fen))
 					if err != nil {
 						return err
@@ -232,8 +352,34 @@ func (c *Client) writePump(g ghess.Board) {
 				if err != nil {
 					fmt.Println(err)
 				}
+				if over {
+					err = rateGame(rec)
+					if err != nil {
+						fmt.Println(err)
+					}
+					startAnalysis(msg.Id)
+					tournamentGameOver(rec)
+				}
 				// Write Message to Clien
 				w.Write([]byte(j))
+			case "moves":
+				mv := &outGo{
+					Type:     "moves",
+					Position: g.Position(),
+					Origin:   msg.Origin,
+					Targets:  legalTargets(&g, msg.Origin),
+				}
+				j, _ := json.Marshal(mv)
+				w.Write([]byte(j))
+			case "refused":
+				// Snap the board back
+				mv := &outGo{
+					Type:     "move",
+					Position: g.Position(),
+					Error:    msg.Message,
+				}
+				j, _ := json.Marshal(mv)
+				w.Write([]byte(j))
 			case "message":
 				chat := &outGo{
 					Type:    "message",
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/polypmer/ghess"
+)
+
+func TestClaimSeat(t *testing.T) {
+	openTestDb(t)
+	game := ghess.NewBoard()
+	put := func(rec Record) {
+		t.Helper()
+		err := db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte("challenges"))
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(rec.Id), []byte(game.Position()))
+			if err != nil {
+				return err
+			}
+			return putRecord(tx, rec)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	claim := func(user, orig, dest string, want bool) {
+		t.Helper()
+		ok, err := claimSeat("c1", user, orig, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("%s %s%s: got %v, want %v", user, orig, dest, ok, want)
+		}
+	}
+	put(Record{Id: "c1", Kind: "challenge", Start: startFen})
+
+	claim("alice", "e2", "e4", true)
+	claim("bob", "e2", "e4", false) // white is alice's now
+	game.Move(ghess.PgnToCoordMap["e2"], ghess.PgnToCoordMap["e4"])
+	var rec Record
+	db.View(func(tx *bolt.Tx) error { rec = getRecord(tx, "c1"); return nil })
+	put(rec)
+	claim("bob", "e7", "e4", true) // an illegal move takes no seat
+	claim("bob", "e7", "e5", true)
+	claim("alice", "e7", "e5", false)
+	db.View(func(tx *bolt.Tx) error { rec = getRecord(tx, "c1"); return nil })
+	if rec.White != "alice" || rec.Black != "bob" {
+		t.Errorf("seats %q %q, want alice bob", rec.White, rec.Black)
+	}
+	rec.Result = "0-1"
+	put(rec)
+	claim("bob", "e7", "e5", false)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polypmer/ghess"
+)
+
+// xboardSession is growser speaking XBoard (CECP v2)
+// with a GUI. It plays with thinkMove, like PlayGame.
+type xboardSession struct {
+	out  io.Writer
+	mu   sync.Mutex // guards out, game and history
+	game ghess.Board
+	// history holds the positions before every move,
+	// for undo.
+	history  []ghess.Board
+	startPly int // plies before the first position
+	force    bool
+	engine   string // "w" or "b", the side growser plays
+	post     bool
+	strength int
+	threads  int
+	over     bool
+
+	// Time controls
+	depth    int           // sd, 0 for the strength's depth
+	st       time.Duration // st, exact time a move
+	mps      int           // level, moves per session
+	inc      time.Duration // level, increment
+	timeLeft time.Duration // time, the engine's clock
+
+	stopCh  chan struct{} // closed to stop the search
+	discard bool          // forget the move being searched
+	wg      sync.WaitGroup
+}
+
+// XboardCommand runs "growser xboard", speaking CECP
+// on stdin and stdout until quit.
+func XboardCommand(args []string) {
+	x := &xboardSession{
+		out:      os.Stdout,
+		strength: uciDefaultStrength,
+		threads:  1,
+	}
+	x.newGame()
+	x.run(os.Stdin)
+}
+
+// send writes a line to the GUI, the caller holds mu.
+func (x *xboardSession) send(format string, a ...interface{}) {
+	fmt.Fprintf(x.out, format+"\n", a...)
+}
+
+// newGame sets up the start, growser plays black.
+func (x *xboardSession) newGame() {
+	x.game = ghess.NewBoard()
+	x.history = nil
+	x.startPly = 0
+	x.force = false
+	x.engine = "b"
+	x.depth = 0
+	x.over = false
+}
+
+// run reads commands until quit or the end of in.
+func (x *xboardSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 1 {
+			continue
+		}
+		args := fields[1:]
+		// Only these may come while growser thinks
+		switch fields[0] {
+		case "?":
+			x.stop(false)
+			continue
+		case "ping":
+			x.mu.Lock()
+			x.send("pong %s", strings.Join(args, " "))
+			x.mu.Unlock()
+			continue
+		case "post", "nopost", "time", "otim", "hard", "easy",
+			"accepted", "rejected", "computer", "random", "xboard":
+			// Harmless while searching
+		case "quit":
+			x.stop(true)
+			return
+		default:
+			x.stop(true)
+		}
+		x.mu.Lock()
+		x.command(fields[0], args)
+		x.mu.Unlock()
+	}
+	x.stop(true)
+}
+
+// command handles a line, with no search running.
+func (x *xboardSession) command(name string, args []string) {
+	switch name {
+	case "protover":
+		x.send("feature myname=\"growser\" setboard=1 usermove=1 " +
+			"ping=1 playother=1 san=0 colors=0 time=1 " +
+			"sigint=0 sigterm=0 reuse=1 analyze=0 memory=1 smp=1 done=1")
+	case "new":
+		x.newGame()
+		transTable.Clear()
+	case "memory":
+		// The GUI gives all growser's memory, the table is most
+		if len(args) > 0 {
+			mb, err := strconv.Atoi(args[0])
+			if err == nil && mb > 0 {
+				transTable = NewTransTable(mb)
+			}
+		}
+	case "cores":
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err == nil && n > 0 && n <= maxThreads {
+				x.threads = n
+			}
+		}
+	case "force":
+		x.force = true
+	case "go":
+		x.force = false
+		x.engine = turnOf(&x.game)
+		x.think()
+	case "playother":
+		x.force = false
+		x.engine = "w"
+		if turnOf(&x.game) == "w" {
+			x.engine = "b"
+		}
+	case "usermove":
+		if len(args) < 1 {
+			x.send("Error (no move): usermove")
+			return
+		}
+		x.userMove(args[0])
+	case "setboard":
+		game, err := loadFen(strings.Join(args, " "))
+		if err != nil {
+			x.send("tellusererror Illegal position: %s", err)
+			return
+		}
+		x.game = game
+		x.history = nil
+		x.startPly = 0
+		if len(args) > 5 {
+			n, _ := strconv.Atoi(args[5])
+			x.startPly = 2 * (n - 1)
+		}
+		if turnOf(&game) == "b" {
+			x.startPly++
+		}
+		x.over = false
+	case "undo":
+		x.undo(1)
+	case "remove":
+		x.undo(2)
+	case "result":
+		x.over = true
+	case "level":
+		x.level(args)
+	case "st":
+		if len(args) > 0 {
+			n, _ := strconv.ParseFloat(args[0], 64)
+			x.st = time.Duration(n * float64(time.Second))
+		}
+	case "sd":
+		if len(args) > 0 {
+			x.depth, _ = strconv.Atoi(args[0])
+		}
+	case "time":
+		if len(args) > 0 {
+			cs, _ := strconv.Atoi(args[0])
+			x.timeLeft = time.Duration(cs) * 10 * time.Millisecond
+		}
+	case "post":
+		x.post = true
+	case "nopost":
+		x.post = false
+	case "xboard", "accepted", "rejected", "otim", "hard",
+		"easy", "computer", "random", "draw":
+	default:
+		// Without usermove=1 moves come bare
+		if _, _, err := parseUciMove(&x.game, name); err == nil {
+			x.userMove(name)
+			return
+		}
+		x.send("Error (unknown command): %s", name)
+	}
+}
+
+// level reads "level MPS BASE INC", BASE in minutes
+// or minutes:seconds and INC in seconds.
+func (x *xboardSession) level(args []string) {
+	if len(args) < 3 {
+		x.send("Error (bad level): %s", strings.Join(args, " "))
+		return
+	}
+	x.mps, _ = strconv.Atoi(args[0])
+	base := strings.SplitN(args[1], ":", 2)
+	min, _ := strconv.Atoi(base[0])
+	x.timeLeft = time.Duration(min) * time.Minute
+	if len(base) > 1 {
+		sec, _ := strconv.Atoi(base[1])
+		x.timeLeft += time.Duration(sec) * time.Second
+	}
+	inc, _ := strconv.ParseFloat(args[2], 64)
+	x.inc = time.Duration(inc * float64(time.Second))
+	x.st = 0
+}
+
+// userMove plays the opponent's move, then thinks if
+// it's growser's turn.
+func (x *xboardSession) userMove(mv string) {
+	if x.over {
+		x.send("Illegal move (game over): %s", mv)
+		return
+	}
+	orig, dest, err := parseUciMove(&x.game, mv)
+	if err != nil {
+		x.send("Illegal move: %s", mv)
+		return
+	}
+	before := x.game
+	err = x.game.Move(orig, dest)
+	if err != nil {
+		x.game = before
+		x.send("Illegal move: %s", mv)
+		return
+	}
+	x.history = append(x.history, before)
+	if x.checkResult() {
+		return
+	}
+	if !x.force && turnOf(&x.game) == x.engine {
+		x.think()
+	}
+}
+
+// undo takes back n plies.
+func (x *xboardSession) undo(n int) {
+	for ; n > 0 && len(x.history) > 0; n-- {
+		x.game = x.history[len(x.history)-1]
+		x.history = x.history[:len(x.history)-1]
+	}
+	x.over = false
+}
+
+// checkResult tells the GUI if the game is over.
+func (x *xboardSession) checkResult() bool {
+	result := gameResult(&x.game)
+	origs, _ := x.game.SearchValid()
+	switch {
+	case result == "1-0":
+		x.send("1-0 {White mates}")
+	case result == "0-1":
+		x.send("0-1 {Black mates}")
+	case result != "":
+		x.send("1/2-1/2 {Draw}")
+	case len(origs) < 1:
+		x.send("1/2-1/2 {Stalemate}")
+	default:
+		return false
+	}
+	x.over = true
+	return true
+}
+
+// moveTime decides how long to think, 0 for no limit.
+func (x *xboardSession) moveTime() time.Duration {
+	if x.st > 0 {
+		return x.st
+	}
+	if x.timeLeft <= 0 {
+		return 0
+	}
+	togo := 30
+	if x.mps > 0 {
+		// Moves growser has left until the next session
+		played := (x.startPly + len(x.history)) / 2
+		togo = x.mps - played%x.mps
+	}
+	t := x.timeLeft/time.Duration(togo) + x.inc/2
+	if t > x.timeLeft/2 {
+		t = x.timeLeft / 2
+	}
+	return t
+}
+
+// think starts a search in the background, the move
+// is played when it's done, the caller holds mu.
+func (x *xboardSession) think() {
+	if x.over {
+		return
+	}
+	game := x.game
+	l := Limits{Depth: x.strength, MoveTime: x.moveTime(),
+		Threads: x.threads}
+	if x.depth > 0 {
+		l.Depth = x.depth
+	}
+	start := time.Now()
+	if x.post {
+		l.Info = func(i Info) {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			// ply score time nodes pv, time in centiseconds
+			x.send("%d %d %d %d %s", i.Depth, xboardScore(i.Score),
+				time.Since(start)/(10*time.Millisecond),
+				i.Nodes, sanPv(&game, i.Pv))
+		}
+	}
+	stopCh := make(chan struct{})
+	l.Stop = stopCh
+	x.stopCh = stopCh
+	x.discard = false
+	ply := x.startPly + len(x.history)
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		res, _, err := thinkMove(&game, ply, x.strength, l)
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		if x.discard {
+			return
+		}
+		if err != nil || res.Orig == 0 {
+			x.send("Error (no move): %v", err)
+			return
+		}
+		mv := uciMove(&game, res.Orig, res.Dest)
+		x.history = append(x.history, x.game)
+		x.game.Move(res.Orig, res.Dest)
+		x.send("move %s", mv)
+		x.checkResult()
+	}()
+}
+
+// stop ends a running search, the move found so far
+// is played unless discard.
+func (x *xboardSession) stop(discard bool) {
+	x.mu.Lock()
+	if x.stopCh != nil {
+		x.discard = discard
+		close(x.stopCh)
+		x.stopCh = nil
+	}
+	x.mu.Unlock()
+	x.wg.Wait()
+}
+
+// xboardScore writes mates as 100000 + moves to mate.
+func xboardScore(score int) int {
+	switch {
+	case score > mateBound:
+		return 100000 + (mateScore-score+1)/2
+	case score < -mateBound:
+		return -100000 - (mateScore+score+1)/2
+	}
+	return score
+}
+
+// sanPv writes a principal variation from g in SAN.
+func sanPv(g *ghess.Board, pv [][2]int) string {
+	b := ghess.CopyBoard(g)
+	moves := make([]string, 0, len(pv))
+	for _, mv := range pv {
+		moves = append(moves, san(b, mv[0], mv[1]))
+		if b.Move(mv[0], mv[1]) != nil {
+			break
+		}
+	}
+	return strings.Join(moves, " ")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXboardSession(t *testing.T) {
+	defer func(saved Engine) { defaultEngine = saved }(defaultEngine)
+	defaultEngine = NegamaxEngine{}
+	x := &xboardSession{strength: 5}
+	x.newGame()
+	e := startEngineIo(t, &x.out, x.run)
+	defer e.quit()
+	e.say("xboard")
+	if line := e.expect("protover 2", "feature"); !strings.HasSuffix(line, "done=1") {
+		t.Errorf("features: %s", line)
+	}
+	e.expect("ping 1", "pong 1")
+
+	// growser plays black after new
+	e.say("new")
+	e.say("cores 1")
+	e.say("sd 2")
+	line := e.expect("usermove e2e4", "move")
+	if move := strings.TrimPrefix(line, "move "); len(move) != 4 || move[1] != '7' && move[1] != '8' {
+		t.Errorf("black played %s", line)
+	}
+	e.expect("usermove e1e3", "Illegal move: e1e3")
+
+	// Scholar's mate
+	e.say("new")
+	e.say("force")
+	e.say("setboard r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4")
+	e.say("sd 3")
+	if line := e.expect("go", "move"); line != "move h5f7" {
+		t.Errorf("mate in one: %s", line)
+	}
+	e.expect("", "1-0")
+	e.expect("usermove e8f7", "Illegal move (game over)")
+}
+
+func TestXboardScore(t *testing.T) {
+	cases := []struct{ score, want int }{
+		{35, 35},
+		{-120, -120},
+		{mateScore - 1, 100001},
+		{mateScore - 3, 100002},
+		{-mateScore + 2, -100001},
+	}
+	for _, c := range cases {
+		if got := xboardScore(c.score); got != c.want {
+			t.Errorf("%d: %d, want %d", c.score, got, c.want)
+		}
+	}
+}